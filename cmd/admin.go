@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"go.uber.org/zap"
+)
+
+// errHelp is returned by parseFlags when -h was given; the usage has been
+// printed and the command has nothing left to do.
+var errHelp = errors.New("help requested")
+
+// openStorage opens the backend selected by STORAGE_TYPE, the same way for
+// the server and the maintenance commands. The returned func releases it.
+func openStorage(ctx context.Context, cfg *config.Config) (storage.Storage, func(), error) {
+	st, err := storage.Open(ctx, storage.StorageType(cfg.Storage.Type), cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {}
+	if closer, ok := st.(interface{ Close() }); ok {
+		release = closer.Close
+	}
+	return st, release, nil
+}
+
+// openAdmin opens the storage for a command that changes raw rows.
+func openAdmin(ctx context.Context, cfg *config.Config) (storage.Admin, func(), error) {
+	st, release, err := openStorage(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	admin, ok := st.(storage.Admin)
+	if !ok {
+		release()
+		return nil, nil, fmt.Errorf("storage %q does not support maintenance commands", cfg.Storage.Type)
+	}
+	return admin, release, nil
+}
+
+// warnIfEphemeral tells the operator that a write to the memory backend is
+// gone as soon as the command exits.
+func warnIfEphemeral(ctx context.Context, cfg *config.Config, command string) {
+	if storage.StorageType(cfg.Storage.Type) == storage.StorageTypeMemory {
+		log.FromContext(ctx).Warn("Memory storage is not persisted, changes are lost when the command exits", zap.String("command", command))
+	}
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return errHelp
+	}
+	return err
+}
+
+// runSeed fills the storage with demo posts and threaded comments.
+func runSeed(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	posts := fs.Int("posts", 10, "number of posts to create")
+	comments := fs.Int("comments", 20, "number of comments per post")
+	authors := fs.Int("authors", 5, "number of distinct authors")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *posts < 0 || *comments < 0 || *authors < 1 || fs.NArg() > 0 {
+		return errors.New("usage: seed [-posts n] [-comments n] [-authors n]")
+	}
+
+	st, release, err := openStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+	warnIfEphemeral(ctx, cfg, "seed")
+
+	authorIDs := make([]uuid.UUID, *authors)
+	for i := range authorIDs {
+		authorIDs[i] = uuid.New()
+	}
+	author := func() uuid.UUID { return authorIDs[rand.IntN(len(authorIDs))] }
+
+	var created int
+	for p := 0; p < *posts; p++ {
+		post, err := st.CreatePost(ctx, &model.NewPost{
+			AuthorID:        author(),
+			Title:           fmt.Sprintf("Seed post %d", p+1),
+			Content:         fmt.Sprintf("Content of seed post %d.", p+1),
+			CommentsAllowed: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create post: %w", err)
+		}
+		ids := make([]int64, 0, *comments)
+		for c := 0; c < *comments; c++ {
+			input := &model.NewComment{
+				AuthorID: author(),
+				PostID:   post.ID,
+				Content:  fmt.Sprintf("Seed comment %d on post %d.", c+1, post.ID),
+			}
+			// About half of the comments answer an earlier one.
+			if len(ids) > 0 && rand.IntN(2) == 0 {
+				parentID := ids[rand.IntN(len(ids))]
+				input.ParentID = &parentID
+			}
+			comment, err := st.CreateComment(ctx, input)
+			if err != nil {
+				return fmt.Errorf("failed to create comment on post %d: %w", post.ID, err)
+			}
+			ids = append(ids, comment.ID)
+			created++
+		}
+	}
+	fmt.Fprintf(out, "created %d posts and %d comments\n", *posts, created)
+	return nil
+}
+
+// runExport writes every post and comment as JSON, to stdout or to -o.
+func runExport(ctx context.Context, cfg *config.Config, args []string, out io.Writer) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "write to this file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("usage: export [-o file]")
+	}
+
+	admin, release, err := openAdmin(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+	snapshot, err := admin.Dump(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	log.FromContext(ctx).Info("Export finished", zap.Int("posts", len(snapshot.Posts)), zap.Int("comments", len(snapshot.Comments)))
+	return nil
+}
+
+// runImport loads a file written by export, or stdin if no file or "-" is
+// given, keeping the IDs it contains.
+func runImport(ctx context.Context, cfg *config.Config, args []string, in io.Reader, out io.Writer) error {
+	if len(args) > 1 {
+		return errors.New("usage: import [file]")
+	}
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	snapshot := &storage.Snapshot{}
+	dec := json.NewDecoder(in)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(snapshot); err != nil {
+		return fmt.Errorf("failed to read import: %w", err)
+	}
+
+	admin, release, err := openAdmin(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+	warnIfEphemeral(ctx, cfg, "import")
+	if err := admin.Restore(ctx, snapshot); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "imported %d posts and %d comments\n", len(snapshot.Posts), len(snapshot.Comments))
+	return nil
+}
+
+// runPurgePost deletes one post with its comments and idempotency keys.
+func runPurgePost(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: purge-post <id>")
+	}
+	postID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid post id %q", args[0])
+	}
+
+	admin, release, err := openAdmin(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+	warnIfEphemeral(ctx, cfg, "purge-post")
+	comments, err := admin.PurgePost(ctx, postID)
+	if err != nil {
+		return fmt.Errorf("failed to purge post %d: %w", postID, err)
+	}
+	fmt.Fprintf(out, "purged post %d and %d comments\n", postID, comments)
+	return nil
+}
+
+// runRecount resynchronizes the ID counters with the stored rows.
+func runRecount(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New("usage: recount")
+	}
+	admin, release, err := openAdmin(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+	counts, err := admin.Recount(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "posts: %d\ncomments: %d\n", counts.Posts, counts.Comments)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func memoryConfig(t *testing.T) *config.Config {
+	t.Setenv("STORAGE_TYPE", "memory")
+	cfg, err := config.Load("")
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestRunImport_ReadsExportFormat(t *testing.T) {
+	cfg := memoryConfig(t)
+	input := `{"posts":[{"id":4,"authorID":"7f1d2c3e-8a7b-4c5d-9e6f-0a1b2c3d4e5f","title":"t","content":"c","commentsAllowed":false,"created_at":"2025-06-04T13:51:52Z"}],
+		"comments":[{"id":9,"authorID":"7f1d2c3e-8a7b-4c5d-9e6f-0a1b2c3d4e5f","postID":4,"content":"hi","created_at":"2025-06-04T13:52:00Z"}]}`
+
+	var out bytes.Buffer
+	require.NoError(t, runImport(context.Background(), cfg, nil, strings.NewReader(input), &out))
+	assert.Equal(t, "imported 1 posts and 1 comments\n", out.String())
+
+	err := runImport(context.Background(), cfg, nil, strings.NewReader(`{"posts":[],"extra":1}`), &out)
+	assert.ErrorContains(t, err, "unknown field")
+}
+
+func TestRunExport_WritesFile(t *testing.T) {
+	cfg := memoryConfig(t)
+	path := filepath.Join(t.TempDir(), "export.json")
+
+	require.NoError(t, runExport(context.Background(), cfg, []string{"-o", path}, &bytes.Buffer{}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var snapshot storage.Snapshot
+	require.NoError(t, json.Unmarshal(data, &snapshot))
+	assert.Empty(t, snapshot.Posts)
+}
+
+func TestAdminCommands_Usage(t *testing.T) {
+	cfg := memoryConfig(t)
+	ctx := context.Background()
+
+	assert.ErrorContains(t, runPurgePost(ctx, cfg, nil, &bytes.Buffer{}), "usage: purge-post")
+	assert.ErrorContains(t, runPurgePost(ctx, cfg, []string{"abc"}, &bytes.Buffer{}), "invalid post id")
+	assert.ErrorContains(t, runPurgePost(ctx, cfg, []string{"1"}, &bytes.Buffer{}), "post not found")
+	assert.ErrorContains(t, runRecount(ctx, cfg, []string{"x"}, &bytes.Buffer{}), "usage: recount")
+	assert.ErrorContains(t, runSeed(ctx, cfg, []string{"-authors", "0"}, &bytes.Buffer{}), "usage: seed")
+}
+
+func TestRunSeed_CreatesPostsAndComments(t *testing.T) {
+	cfg := memoryConfig(t)
+
+	var out bytes.Buffer
+	require.NoError(t, runSeed(context.Background(), cfg, []string{"-posts", "3", "-comments", "4"}, &out))
+	assert.Equal(t, "created 3 posts and 12 comments\n", out.String())
+}
@@ -2,91 +2,122 @@ package main
 
 import (
 	"context"
-	"net/http"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 
-	"github.com/99designs/gqlgen/graphql/handler"
-	"github.com/99designs/gqlgen/graphql/handler/extension"
-	"github.com/99designs/gqlgen/graphql/handler/transport"
-	"github.com/99designs/gqlgen/graphql/playground"
-	"github.com/iamstep4ik/TestTaskOzonBank/graph"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
-	commentservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/comment_service"
-	postservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/post_service"
-	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	_ "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/db"
+	_ "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
-const defaultPort = "8080"
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		panic("Error loading .env file")
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal("Error loading .env file: %v", err)
+	}
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets masked and exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Invalid configuration:\n%v", err)
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fatal("Error printing configuration: %v", err)
+		}
+		return
 	}
 
 	logDir := "./logs"
 
 	if err := os.MkdirAll(logDir, 0755); err != nil {
-		panic("Error creating log directory: " + err.Error())
+		fatal("Error creating log directory: %v", err)
 	}
 	logFile := filepath.Join(logDir, "app.log")
-	err := log.Initialize(log.Config{
-		LogFile:    logFile,
-		LogLevel:   os.Getenv("LOG_LEVEL"),
-		MaxSizeMB:  100,
-		MaxBackups: 10,
-		MaxAgeDays: 30,
-		Compress:   true,
-		Console:    true,
+	err = log.Initialize(log.Config{
+		LogFile:       logFile,
+		LogLevel:      cfg.Log.Level,
+		MaxSizeMB:     100,
+		MaxBackups:    10,
+		MaxAgeDays:    30,
+		Compress:      true,
+		Console:       true,
+		PackageLevels: cfg.Log.PackageLevels,
+		Sampling: log.SamplingConfig{
+			Initial:    cfg.Log.Sampling.Initial,
+			Thereafter: cfg.Log.Sampling.Thereafter,
+			Tick:       cfg.Log.Sampling.Tick,
+		},
+		Redaction: log.RedactionConfig{
+			Content: cfg.Log.Redaction.Content,
+			IDs:     cfg.Log.Redaction.IDs,
+		},
 	})
 	if err != nil {
-		panic("Error initializing logger: " + err.Error())
+		fatal("Error initializing logger: %v", err)
 	}
 	defer log.Sync()
+	ctx := context.Background()
 
-	cfg, err := config.NewConfig()
-	if err != nil {
-		log.Error("Error loading configuration", zap.Error(err))
-		return
+	command, args := "serve", []string(nil)
+	if flag.NArg() > 0 {
+		command, args = flag.Arg(0), flag.Args()[1:]
 	}
-	ctx := context.Background()
-	dbpool, err := cfg.ConnectDatabase(ctx, cfg)
-	if err != nil {
-		log.Error("Error connecting to database", zap.Error(err))
-		return
+	switch command {
+	case "serve":
+		err = runServe(ctx, cfg, *configPath)
+	case "migrate":
+		err = runMigrate(ctx, cfg, args, os.Stdout)
+	case "seed":
+		err = runSeed(ctx, cfg, args, os.Stdout)
+	case "export":
+		err = runExport(ctx, cfg, args, os.Stdout)
+	case "import":
+		err = runImport(ctx, cfg, args, os.Stdin, os.Stdout)
+	case "purge-post":
+		err = runPurgePost(ctx, cfg, args, os.Stdout)
+	case "recount":
+		err = runRecount(ctx, cfg, args, os.Stdout)
+	default:
+		err = fmt.Errorf("unknown command %q, run with -h for the list of commands", command)
 	}
-	log.Info("Database connection established", zap.String("host", cfg.Database.Host), zap.String("port", cfg.Database.Port), zap.String("name", cfg.Database.Name))
-	defer dbpool.Close()
-	storageName := os.Getenv("STORAGE_TYPE")
-	storage := storage.NewStorage(ctx, dbpool)
-
-	log.Info("Using storage", zap.String("type", storageName))
-
-	postService := postservice.NewPostService(storage, log.GetLogger())
-	commentService := commentservice.NewCommentService(storage, log.GetLogger())
-	resolver := graph.NewResolver(postService, commentService)
+	if err != nil && !errors.Is(err, errHelp) {
+		log.Error("Command failed", zap.String("command", command), zap.Error(err))
+		log.Sync()
+		os.Exit(1)
+	}
+}
 
-	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
-	srv.AddTransport(transport.Options{})
-	srv.AddTransport(transport.Websocket{})
-	srv.AddTransport(transport.GET{})
-	srv.AddTransport(transport.POST{})
-	srv.Use(extension.Introspection{})
+const commandsUsage = `Commands:
+  serve                       run the GraphQL server (default)
+  migrate up|down|redo|status apply or inspect database migrations
+  seed [flags]                create demo posts and comments
+  export [-o file]            write all posts and comments as JSON
+  import [file]               load posts and comments written by export
+  purge-post <id>             delete a post with all its comments
+  recount                     move ID counters past the stored rows
+`
 
-	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", srv)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
-	}
-	log.Info("Starting server", zap.String("port", port))
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Error("Error starting server", zap.Error(err))
-		return
-	}
-	log.Info("Server started successfully", zap.String("port", port))
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command] [args]\n\nFlags:\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+	fmt.Fprint(flag.CommandLine.Output(), "\n"+commandsUsage)
+}
 
+// fatal reports errors that happen before the logger is ready.
+func fatal(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/migrations"
+	"github.com/pressly/goose/v3"
+)
+
+const migrateUsage = "usage: migrate up|down|status|redo"
+
+// runMigrate applies or inspects the migrations embedded in the binary.
+func runMigrate(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up", "down", "redo", "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", args[0], migrateUsage)
+	}
+
+	pool, err := cfg.ConnectDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	migrator, err := migrations.NewMigrator(pool)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	var results []*goose.MigrationResult
+	switch args[0] {
+	case "up":
+		results, err = migrator.Up(ctx)
+		if err == nil && len(results) == 0 {
+			fmt.Fprintln(out, "no migrations to apply")
+		}
+	case "down":
+		var result *goose.MigrationResult
+		result, err = migrator.Down(ctx)
+		if result != nil {
+			results = append(results, result)
+		}
+	case "redo":
+		results, err = migrator.Redo(ctx)
+	case "status":
+		return printMigrationStatus(ctx, migrator, out)
+	}
+	for _, result := range results {
+		fmt.Fprintln(out, result)
+	}
+	return err
+}
+
+func printMigrationStatus(ctx context.Context, migrator *migrations.Migrator, out io.Writer) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APPLIED AT\tMIGRATION")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.State == goose.StateApplied {
+			appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", appliedAt, filepath.Base(status.Source.Path))
+	}
+	return w.Flush()
+}
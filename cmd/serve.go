@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/gorilla/websocket"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/auth"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/health"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/metrics"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/middleware"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/outbox"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/ratelimit"
+	commentservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/comment_service"
+	postservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/post_service"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/sse"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	cache "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/cache.go"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/tracing"
+	"github.com/iamstep4ik/TestTaskOzonBank/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// runServe starts the GraphQL server and blocks until it is stopped by
+// SIGINT or SIGTERM and has drained.
+func runServe(ctx context.Context, cfg *config.Config, configPath string) error {
+	go reloadLogLevelsOnSIGHUP(configPath)
+
+	shutdownTracing, err := tracing.Initialize(ctx, tracing.Config{
+		Exporter:     cfg.Tracing.Exporter,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+		ServiceName:  cfg.Tracing.ServiceName,
+		Version:      version,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Error("Error flushing traces", zap.Error(err))
+		}
+	}()
+
+	st, closeStorage, err := openStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	log.Info("Using storage", zap.String("type", cfg.Storage.Type))
+
+	if keys, ok := st.(storage.IdempotencyKeys); ok {
+		purgeCtx, stopPurge := context.WithCancel(ctx)
+		defer stopPurge()
+		go purgeIdempotencyKeys(purgeCtx, keys, min(cfg.Idempotency.TTL, time.Hour))
+	}
+
+	var redisClient *redis.Client
+	if (cfg.APQ.Enabled && cfg.APQ.Cache == "redis") || (cfg.RateLimit.Enabled && cfg.RateLimit.Backend == "redis") ||
+		cfg.SubscriptionBroker() == "redis" {
+		redisClient, err = cfg.ConnectRedis(ctx)
+		if err != nil {
+			return err
+		}
+		defer redisClient.Close()
+		log.Info("Redis connection established", zap.String("addr", cfg.Redis.Addr))
+	}
+
+	var broker subscription.Broker
+	switch cfg.SubscriptionBroker() {
+	case "memory":
+		broker = subscription.NewMemoryBroker()
+	case "postgres":
+		pooled, ok := st.(interface{ Pool() *pgxpool.Pool })
+		if !ok {
+			return fmt.Errorf("storage %q has no database pool for the postgres broker", cfg.Storage.Type)
+		}
+		broker = subscription.NewPostgresBroker(pooled.Pool())
+	case "redis":
+		broker = subscription.NewRedisBroker(redisClient)
+	}
+	subscriptionService, err := subscription.NewSubscriptionService(ctx, broker, subscription.Options{
+		Buffer:       cfg.Subscriptions.Buffer,
+		Policy:       subscription.Policy(cfg.Subscriptions.SlowPolicy),
+		BlockTimeout: cfg.Subscriptions.BlockTimeout,
+		TypingTTL:    cfg.Subscriptions.TypingTTL,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start subscription broker: %w", err)
+	}
+	defer subscriptionService.Close()
+	log.Info("Subscription broker started", zap.String("broker", cfg.SubscriptionBroker()))
+
+	events, ok := st.(storage.Outbox)
+	if !ok {
+		return fmt.Errorf("storage %q has no outbox", cfg.Storage.Type)
+	}
+	relay := outbox.NewRelay(events, outbox.Options{
+		PollInterval: cfg.Outbox.PollInterval,
+		BatchSize:    cfg.Outbox.BatchSize,
+		Retention:    cfg.Outbox.Retention,
+		MaxAttempts:  cfg.Outbox.MaxAttempts,
+		MaxBackoff:   cfg.Outbox.MaxBackoff,
+	}, subscriptionService)
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(relayCtx)
+	}()
+	defer func() {
+		stopRelay()
+		<-relayDone
+	}()
+
+	var appMetrics *metrics.Metrics
+	serviceStorage := st
+	if cfg.Metrics.Enabled {
+		appMetrics = metrics.New()
+		appMetrics.Register(metrics.NewSubscriptionCollector(subscriptionService))
+		if pooled, ok := st.(interface{ Pool() *pgxpool.Pool }); ok {
+			appMetrics.Register(metrics.NewPoolCollector(pooled.Pool()))
+		}
+		// Optional interfaces are asserted on st, the wrapper hides them.
+		serviceStorage = appMetrics.InstrumentStorage(st, cfg.Storage.Type)
+	}
+
+	postService := postservice.NewPostService(serviceStorage, log.GetLogger())
+	commentService := commentservice.NewCommentService(serviceStorage, log.GetLogger())
+	resolver := graph.NewResolver(postService, commentService, subscriptionService)
+
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{
+		Resolvers:  resolver,
+		Complexity: graph.NewComplexityRoot(),
+	}))
+	tokens := auth.NewTokens(cfg.Subscriptions.AuthTokens)
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.Websocket{
+		Upgrader: websocket.Upgrader{
+			// graphql-transport-ws is preferred; graphql-ws is kept for
+			// older clients.
+			Subprotocols: []string{"graphql-transport-ws", "graphql-ws"},
+			CheckOrigin:  middleware.CheckOrigin(cfg.Websocket.AllowedOrigins),
+		},
+		InitFunc: func(ctx context.Context, initPayload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+			ctx, err := tokens.FromInitPayload(ctx, initPayload)
+			if err != nil {
+				return ctx, nil, err
+			}
+			// The payload carries the token now, so it isn't echoed in the ack.
+			ctx = tracing.FromInitPayload(ctx, initPayload)
+			return gqlext.TrackConnection(ctx), nil, nil
+		},
+		InitTimeout:           cfg.Websocket.InitTimeout,
+		KeepAlivePingInterval: cfg.Subscriptions.KeepAlive,
+		PingPongInterval:      cfg.Subscriptions.KeepAlive,
+	})
+	srv.AddTransport(transport.SSE{KeepAlivePingInterval: cfg.Subscriptions.KeepAlive})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(tracing.GraphQL{})
+	srv.Use(gqlext.RequestLogger{})
+	srv.Use(gqlext.SubscriptionErrors{})
+	srv.Use(gqlext.SubscriptionAuth{})
+	srv.Use(gqlext.SubscriptionLimit{Max: cfg.Websocket.MaxSubscriptions})
+	var manifest map[string]string
+	if cfg.PersistedQueries.Manifest != "" {
+		manifest, err = gqlext.LoadPersistedQueryManifest(cfg.PersistedQueries.Manifest)
+		if err != nil {
+			return fmt.Errorf("failed to load persisted query manifest: %w", err)
+		}
+	}
+	if appMetrics != nil {
+		srv.Use(appMetrics.GraphQL(slices.Concat(cfg.Metrics.OperationNames, gqlext.OperationNames(manifest))...))
+	}
+	srv.Use(gqlext.DepthLimit{MaxDepth: cfg.GraphQL.MaxDepth})
+	srv.Use(extension.FixedComplexityLimit(cfg.GraphQL.MaxComplexity))
+
+	if manifest != nil {
+		srv.Use(gqlext.PersistedQueryAllowlist{Manifest: manifest, Strict: cfg.PersistedQueries.Strict})
+		log.Info("Persisted query allowlist loaded", zap.Int("queries", len(manifest)), zap.Bool("strict", cfg.PersistedQueries.Strict))
+	}
+
+	if cfg.APQ.Enabled {
+		var apqCache graphql.Cache[string]
+		switch cfg.APQ.Cache {
+		case "lru":
+			apqCache = lru.New[string](cfg.APQ.CacheSize)
+		case "redis":
+			apqCache = cache.NewRedisCache(redisClient, "apq:", cfg.APQ.CacheTTL)
+		default:
+			return fmt.Errorf("unknown APQ cache type %q", cfg.APQ.Cache)
+		}
+		srv.Use(extension.AutomaticPersistedQuery{Cache: apqCache})
+		log.Info("Automatic persisted queries enabled", zap.String("cache", cfg.APQ.Cache))
+	}
+
+	// The event stream is limited by the commentAdded rule, like the
+	// subscription it stands in for.
+	postEvents := &sse.PostEvents{
+		Subscriptions: subscriptionService,
+		Posts:         postService,
+		Load:          commentService.GetCommentsAfter,
+		KeepAlive:     cfg.Subscriptions.KeepAlive,
+	}
+	if cfg.RateLimit.Enabled {
+		rules, err := ratelimit.ParseRules(cfg.RateLimit.Rules)
+		if err != nil {
+			return fmt.Errorf("failed to parse rate limit rules: %w", err)
+		}
+		var limiter ratelimit.Limiter
+		switch cfg.RateLimit.Backend {
+		case "memory":
+			limiter = ratelimit.NewMemoryLimiter()
+		case "redis":
+			limiter = ratelimit.NewRedisLimiter(redisClient, "ratelimit:")
+		default:
+			return fmt.Errorf("unknown rate limit backend %q", cfg.RateLimit.Backend)
+		}
+		srv.Use(gqlext.RateLimit{Limiter: limiter, Rules: rules})
+		if rule, ok := rules["commentAdded"]; ok {
+			postEvents.Limiter, postEvents.Rule = limiter, rule
+		}
+		log.Info("Rate limiting enabled", zap.String("backend", cfg.RateLimit.Backend), zap.Int("rules", len(rules)))
+	}
+
+	healthHandler := health.New(health.ReadBuildInfo(version), cfg.Storage.Type)
+	healthHandler.AddReadinessCheck("storage", st.Ping)
+	if pooled, ok := st.(interface{ Pool() *pgxpool.Pool }); ok {
+		// The migrator also reports migrations skipped out of order, so
+		// readiness agrees with `migrate status`.
+		migrator, err := migrations.NewMigrator(pooled.Pool())
+		if err != nil {
+			return err
+		}
+		defer migrator.Close()
+		healthHandler.AddReadinessCheck("migrations", migrator.Check)
+	}
+	healthHandler.AddReadinessCheck("subscriptions", func(ctx context.Context) error {
+		if !subscriptionService.Running() {
+			return errors.New("subscription service stopped")
+		}
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthHandler.Liveness())
+	mux.Handle("/readyz", healthHandler.Readiness())
+	mux.Handle("/status", healthHandler.Status())
+	if appMetrics != nil {
+		mux.Handle("/metrics", appMetrics.Handler())
+	}
+	if cfg.Log.AdminEnabled {
+		mux.Handle("/admin/log/level", auth.NewTokens(cfg.Log.AdminTokens).Require(log.LevelHandler()))
+	}
+	// Event streams go through the same middleware as /query and share its
+	// connection limit.
+	connections := middleware.NewConnectionLimit(cfg.Subscriptions.MaxConnectionsPerIP)
+	withMiddleware := func(h http.Handler) http.Handler {
+		return tracing.Middleware(middleware.RequestID(middleware.ClientIP(cfg.Server.TrustProxyHeaders)(h)))
+	}
+	mux.Handle("/", playground.Handler("GraphQL playground", "/query"))
+	mux.Handle("/query", withMiddleware(connections.Streams(tokens.Middleware(srv))))
+	mux.Handle("GET /events/posts/{id}", withMiddleware(connections.Middleware(tokens.Require(postEvents))))
+	port := cfg.Server.Port
+
+	// Hijacked websocket connections are not tracked by http.Server, so they
+	// are closed by cancelling the base context they were accepted with.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	// Event streams over HTTP keep their requests active, so they are ended
+	// as soon as shutdown starts for it to be able to drain.
+	server.RegisterOnShutdown(subscriptionService.Close)
+
+	signalCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info("Starting server", zap.String("port", port))
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-signalCtx.Done():
+		stop()
+	}
+
+	log.Info("Shutting down server", zap.Duration("timeout", cfg.Server.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("Error draining HTTP requests", zap.Error(err))
+	}
+	// Undelivered events stay in the outbox for the next run or another
+	// instance.
+	stopRelay()
+	<-relayDone
+	subscriptionService.Close()
+	cancelBase()
+	log.Info("Server stopped")
+	return nil
+}
+
+// purgeIdempotencyKeys removes the expired idempotency keys of all authors
+// every interval until ctx is done. Keys are also checked for expiry when
+// they are used, so this only keeps the table from growing.
+func purgeIdempotencyKeys(ctx context.Context, keys storage.IdempotencyKeys, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := keys.PurgeIdempotencyKeys(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("Failed to purge idempotency keys", zap.Error(err))
+				}
+				continue
+			}
+			if n > 0 {
+				log.Info("Expired idempotency keys purged", zap.Int64("keys", n))
+			}
+		}
+	}
+}
+
+// reloadLogLevelsOnSIGHUP re-reads .env, the config file and the environment
+// and applies the log levels found there, without restarting the server.
+func reloadLogLevelsOnSIGHUP(configPath string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := godotenv.Overload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("Error reloading .env file", zap.Error(err))
+		}
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			log.Error("Error reloading configuration", zap.Error(err))
+			continue
+		}
+		if err := log.SetLevels(cfg.Log.Level, cfg.Log.PackageLevels); err != nil {
+			log.Error("Error applying log levels", zap.Error(err))
+			continue
+		}
+		log.Info("Log levels reloaded", zap.String("level", cfg.Log.Level), zap.Any("packages", cfg.Log.PackageLevels))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	inmemory "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const closingStorageType storage.StorageType = "closing-memory"
+
+// closingStorage is the memory backend with a Close, standing in for the
+// pool that the db backend closes on shutdown.
+type closingStorage struct {
+	*inmemory.StorageMemory
+	closed atomic.Bool
+}
+
+func (s *closingStorage) Close() {
+	s.closed.Store(true)
+}
+
+var (
+	opened  = make(chan *closingStorage, 1)
+	logOnce sync.Once
+)
+
+func init() {
+	storage.Register(closingStorageType, func(ctx context.Context, cfg *config.Config) (storage.Storage, error) {
+		st := &closingStorage{StorageMemory: inmemory.NewStorageMemory(cfg.Idempotency.TTL)}
+		opened <- st
+		return st, nil
+	})
+}
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestRunServe_DrainsOnShutdown(t *testing.T) {
+	logOnce.Do(func() { require.NoError(t, log.Initialize(log.Config{LogLevel: "error"})) })
+	cfg := memoryConfig(t)
+	cfg.Storage.Type = string(closingStorageType)
+	cfg.Server.Port = freePort(t)
+	cfg.Server.ShutdownTimeout = 5 * time.Second
+	base := "http://127.0.0.1:" + cfg.Server.Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		served <- runServe(ctx, cfg, "")
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+		}
+	})
+	st := <-opened
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(base + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := http.Post(base+"/query", "application/json", strings.NewReader(
+		`{"query":"mutation { createPost(postInput: {authorID: \"7f1d2c3e-8a7b-4c5d-9e6f-0a1b2c3d4e5f\", title: \"t\", content: \"c\", commentsAllowed: true}) { id } }"}`))
+	require.NoError(t, err)
+	var created struct {
+		Data struct{ CreatePost struct{ ID int64 } }
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+
+	stream, err := http.Get(fmt.Sprintf("%s/events/posts/%d", base, created.Data.CreatePost.ID))
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	events := bufio.NewReader(stream.Body)
+	line, err := events.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, ":\n", line)
+
+	// The request is in flight until its body is sent. With Expect:
+	// 100-continue the body waits for the handler to start reading it.
+	body, write := io.Pipe()
+	reading := make(chan struct{})
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got100Continue: func() { close(reading) },
+	}), http.MethodPost, base+"/query", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Expect", "100-continue")
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: time.Minute}}
+	inFlight := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			close(inFlight)
+			return
+		}
+		inFlight <- resp
+	}()
+	select {
+	case <-reading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the in-flight request was not handled")
+	}
+
+	cancel()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+cfg.Server.Port)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "new connections must be refused once shutdown starts")
+
+	streamEnded := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, events)
+		streamEnded <- err
+	}()
+	select {
+	case err := <-streamEnded:
+		assert.NoError(t, err, "the event stream must end cleanly")
+	case <-time.After(cfg.Server.ShutdownTimeout / 2):
+		t.Fatal("the event stream was not ended when shutdown started")
+	}
+
+	_, err = io.WriteString(write, `{"query":"{ posts { id } }"}`)
+	require.NoError(t, err)
+	require.NoError(t, write.Close())
+	resp, ok := <-inFlight
+	require.True(t, ok)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "a request in flight when shutdown starts must finish")
+	var posts struct {
+		Data struct{ Posts []struct{ ID int64 } }
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&posts))
+	assert.Len(t, posts.Data.Posts, 1)
+
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServe did not return after shutdown")
+	}
+	assert.True(t, st.closed.Load(), "the storage must be closed after the server stops")
+}
@@ -0,0 +1,83 @@
+package graph
+
+import (
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	commentservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/comment_service"
+)
+
+// This file will not be regenerated automatically.
+//
+// It holds the cost model used by the complexity limit on /query.
+
+const (
+	defaultCommentsLimit = int64(20)
+	// posts is not paginated, so its fan-out is estimated with a fixed page size.
+	postsPageEstimate = int64(20)
+	// depth is computed with a recursive query for every comment it is selected on.
+	depthCost = 5
+	// mutationCost accounts for the write transaction behind every mutation.
+	mutationCost = 10
+)
+
+func NewComplexityRoot() ComplexityRoot {
+	var c ComplexityRoot
+
+	c.Query.Posts = func(childComplexity int) int {
+		return listCost(childComplexity, postsPageEstimate)
+	}
+	c.Post.Comments = func(childComplexity int, offset *int64, limit *int64) int {
+		return listCost(childComplexity, pageSize(limit, defaultCommentsLimit))
+	}
+	c.Comment.Replies = func(childComplexity int, offset *int64, limit *int64) int {
+		return listCost(childComplexity, pageSize(limit, commentservice.DefaultRepliesLimit))
+	}
+	c.Comment.Depth = func(childComplexity int) int {
+		return depthCost
+	}
+
+	c.Mutation.CreatePost = func(childComplexity int, postInput model.NewPost) int {
+		return childComplexity + mutationCost
+	}
+	c.Mutation.CreateComment = func(childComplexity int, commentInput model.NewComment) int {
+		return childComplexity + mutationCost
+	}
+	c.Mutation.UpdateAllowComments = func(childComplexity int, postID int64, authorID uuid.UUID, commentsAllowed bool) int {
+		return childComplexity + mutationCost
+	}
+	c.Mutation.UpdatePost = func(childComplexity int, postID int64, authorID uuid.UUID, title *string, content *string) int {
+		return childComplexity + mutationCost
+	}
+	c.Mutation.EditComment = func(childComplexity int, commentID int64, authorID uuid.UUID, content string) int {
+		return childComplexity + mutationCost
+	}
+	c.Mutation.DeleteComment = func(childComplexity int, commentID int64, authorID uuid.UUID) int {
+		return childComplexity + mutationCost
+	}
+	c.Mutation.SetTyping = func(childComplexity int, postID int64, authorID uuid.UUID) int {
+		return childComplexity + mutationCost
+	}
+
+	return c
+}
+
+func pageSize(limit *int64, def int64) int64 {
+	if limit == nil || *limit <= 0 {
+		return def
+	}
+	return *limit
+}
+
+// listCost charges the selection once per requested element, saturating
+// instead of overflowing so huge limits are always rejected.
+func listCost(childComplexity int, size int64) int {
+	if childComplexity == 0 {
+		childComplexity = 1
+	}
+	if size > int64(math.MaxInt-1)/int64(childComplexity) {
+		return math.MaxInt
+	}
+	return 1 + childComplexity*int(size)
+}
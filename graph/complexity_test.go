@@ -0,0 +1,58 @@
+package graph_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph"
+	commentservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/comment_service"
+	postservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/post_service"
+	inmemory "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// postsWithComments costs 1 + 20*(1 + (1 + 5*1)) = 141: the posts page
+// estimate times each post's id and its five comments.
+const postsWithComments = `{ posts { id comments(limit: 5) { id } } }`
+
+func newClient(maxComplexity int) *client.Client {
+	st := inmemory.NewStorageMemory(time.Hour)
+	resolver := graph.NewResolver(postservice.NewPostService(st, zap.NewNop()), commentservice.NewCommentService(st, zap.NewNop()), nil)
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{
+		Resolvers:  resolver,
+		Complexity: graph.NewComplexityRoot(),
+	}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.FixedComplexityLimit(maxComplexity))
+	return client.New(srv)
+}
+
+func TestComplexityLimit_AllowsQueryAtLimit(t *testing.T) {
+	var resp struct {
+		Posts []struct{ ID int64 }
+	}
+	require.NoError(t, newClient(141).Post(postsWithComments, &resp))
+	assert.Empty(t, resp.Posts)
+}
+
+func TestComplexityLimit_RejectsQueryOverLimit(t *testing.T) {
+	var resp struct{}
+	err := newClient(140).Post(postsWithComments, &resp)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "COMPLEXITY_LIMIT_EXCEEDED")
+	assert.Contains(t, err.Error(), "complexity 141")
+}
+
+func TestComplexityLimit_PricesSetTypingAsMutation(t *testing.T) {
+	var resp struct{}
+	err := newClient(9).Post(`mutation { setTyping(postID: 1, authorID: "6f1c0a2e-3b1d-4c55-9a3e-1f2d3c4b5a69") }`, &resp)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "COMPLEXITY_LIMIT_EXCEEDED")
+	assert.Contains(t, err.Error(), "complexity 10")
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+)
+
+// This file will not be regenerated automatically.
+//
+// It connects subscription resolvers to the subscription service. Mutations
+// don't publish events: storage records them in its outbox, which the outbox
+// relay delivers.
+
+// stream returns the events of sub converted for a subscription resolver;
+// events convert maps to false are skipped. The channel is closed once sub
+// has ended, and the reason is reported by the SubscriptionErrors extension.
+func stream[T any](ctx context.Context, sub *subscription.Subscription, convert func(*subscription.Event) (T, bool)) <-chan T {
+	gqlext.WatchSubscription(ctx, sub.Err)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for event := range sub.C() {
+			v, ok := convert(event)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func commentOf(event *subscription.Event) (*model.Comment, bool) {
+	return event.Comment, event.Comment != nil
+}
+
+func postOf(event *subscription.Event) (*model.Post, bool) {
+	return event.Post, event.Post != nil
+}
+
+func presenceOf(event *subscription.Event) (*model.Presence, bool) {
+	return event.Presence, event.Presence != nil
+}
+
+func postActivity(event *subscription.Event) (model.PostActivity, bool) {
+	switch event.Kind {
+	case subscription.EventCommentAdded:
+		return &model.CommentAdded{Comment: event.Comment}, event.Comment != nil
+	case subscription.EventCommentEdited:
+		return &model.CommentEdited{Comment: event.Comment}, event.Comment != nil
+	case subscription.EventCommentDeleted:
+		return &model.CommentDeleted{PostID: event.PostID, CommentID: event.CommentID}, true
+	case subscription.EventCommentsToggled:
+		if event.Post == nil {
+			return nil, false
+		}
+		return &model.CommentsToggled{PostID: event.PostID, CommentsAllowed: event.Post.CommentsAllowed}, true
+	case subscription.EventPostUpdated:
+		return &model.PostUpdated{Post: event.Post}, event.Post != nil
+	}
+	return nil, false
+}
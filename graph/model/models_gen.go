@@ -8,6 +8,10 @@ import (
 	"github.com/google/uuid"
 )
 
+type PostActivity interface {
+	IsPostActivity()
+}
+
 type Comment struct {
 	ID        int64      `json:"id"`
 	AuthorID  uuid.UUID  `json:"authorID"`
@@ -19,14 +23,41 @@ type Comment struct {
 	Depth     int        `json:"depth"`
 }
 
+type CommentAdded struct {
+	Comment *Comment `json:"comment"`
+}
+
+func (CommentAdded) IsPostActivity() {}
+
+type CommentDeleted struct {
+	PostID    int64 `json:"postID"`
+	CommentID int64 `json:"commentID"`
+}
+
+func (CommentDeleted) IsPostActivity() {}
+
+type CommentEdited struct {
+	Comment *Comment `json:"comment"`
+}
+
+func (CommentEdited) IsPostActivity() {}
+
+type CommentsToggled struct {
+	PostID          int64 `json:"postID"`
+	CommentsAllowed bool  `json:"commentsAllowed"`
+}
+
+func (CommentsToggled) IsPostActivity() {}
+
 type Mutation struct {
 }
 
 type NewComment struct {
-	AuthorID uuid.UUID `json:"authorID"`
-	PostID   int64     `json:"postID"`
-	ParentID *int64    `json:"parentID,omitempty"`
-	Content  string    `json:"content"`
+	AuthorID       uuid.UUID `json:"authorID"`
+	PostID         int64     `json:"postID"`
+	ParentID       *int64    `json:"parentID,omitempty"`
+	Content        string    `json:"content"`
+	IdempotencyKey *string   `json:"idempotencyKey,omitempty"`
 }
 
 type NewPost struct {
@@ -34,6 +65,7 @@ type NewPost struct {
 	Title           string    `json:"title"`
 	Content         string    `json:"content"`
 	CommentsAllowed bool      `json:"commentsAllowed"`
+	IdempotencyKey  *string   `json:"idempotencyKey,omitempty"`
 }
 
 type Post struct {
@@ -44,6 +76,19 @@ type Post struct {
 	CommentsAllowed bool       `json:"commentsAllowed"`
 	Comments        []*Comment `json:"comments"`
 	CreatedAt       time.Time  `json:"created_at"`
+	ActiveViewers   int        `json:"activeViewers"`
+}
+
+type PostUpdated struct {
+	Post *Post `json:"post"`
+}
+
+func (PostUpdated) IsPostActivity() {}
+
+type Presence struct {
+	PostID  int64       `json:"postID"`
+	Viewers int         `json:"viewers"`
+	Typing  []uuid.UUID `json:"typing"`
 }
 
 type Query struct {
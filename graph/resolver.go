@@ -16,10 +16,10 @@ type Resolver struct {
 	SubscriptionService *subscription.SubscriptionService
 }
 
-func NewResolver(postService *postservice.PostService, commentService *commentservice.CommentService) *Resolver {
+func NewResolver(postService *postservice.PostService, commentService *commentservice.CommentService, subscriptionService *subscription.SubscriptionService) *Resolver {
 	return &Resolver{
 		PostService:         postService,
 		CommentService:      commentService,
-		SubscriptionService: subscription.NewSubscriptionService(),
+		SubscriptionService: subscriptionService,
 	}
 }
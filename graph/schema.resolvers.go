@@ -10,6 +10,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
 )
 
 // Replies is the resolver for the replies field.
@@ -45,7 +47,6 @@ func (r *mutationResolver) CreateComment(ctx context.Context, commentInput model
 	if err != nil {
 		return nil, fmt.Errorf("failed to create comment: %w", err)
 	}
-	r.SubscriptionService.Publish(comment.PostID, comment)
 	return comment, nil
 }
 
@@ -58,9 +59,51 @@ func (r *mutationResolver) UpdateAllowComments(ctx context.Context, postID int64
 	return post, nil
 }
 
+// UpdatePost is the resolver for the updatePost field.
+func (r *mutationResolver) UpdatePost(ctx context.Context, postID int64, authorID uuid.UUID, title *string, content *string) (*model.Post, error) {
+	post, err := r.PostService.UpdatePost(ctx, authorID.String(), postID, title, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update post: %w", err)
+	}
+	return post, nil
+}
+
+// EditComment is the resolver for the editComment field.
+func (r *mutationResolver) EditComment(ctx context.Context, commentID int64, authorID uuid.UUID, content string) (*model.Comment, error) {
+	comment, err := r.CommentService.EditComment(ctx, authorID.String(), commentID, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit comment: %w", err)
+	}
+	return comment, nil
+}
+
+// DeleteComment is the resolver for the deleteComment field.
+func (r *mutationResolver) DeleteComment(ctx context.Context, commentID int64, authorID uuid.UUID) (bool, error) {
+	_, err := r.CommentService.DeleteComment(ctx, authorID.String(), commentID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete comment: %w", err)
+	}
+	return true, nil
+}
+
+// SetTyping is the resolver for the setTyping field.
+func (r *mutationResolver) SetTyping(ctx context.Context, postID int64, authorID uuid.UUID) (bool, error) {
+	post, err := r.PostService.GetPost(ctx, postID)
+	if err != nil {
+		return false, fmt.Errorf("failed to set typing: %w", err)
+	}
+	if !post.CommentsAllowed {
+		return false, fmt.Errorf("failed to set typing: %w", errs.ErrCommentsNotAllowed)
+	}
+	if err := r.SubscriptionService.SetTyping(ctx, postID, authorID); err != nil {
+		return false, fmt.Errorf("failed to set typing: %w", err)
+	}
+	return true, nil
+}
+
 // Comments is the resolver for the comments field.
 func (r *postResolver) Comments(ctx context.Context, obj *model.Post, offset *int64, limit *int64) ([]*model.Comment, error) {
-	defaultLimit := int64(20)
+	defaultLimit := defaultCommentsLimit
 	defaultOffset := int64(0)
 	if limit == nil {
 		limit = &defaultLimit
@@ -75,6 +118,11 @@ func (r *postResolver) Comments(ctx context.Context, obj *model.Post, offset *in
 	return comments, nil
 }
 
+// ActiveViewers is the resolver for the activeViewers field.
+func (r *postResolver) ActiveViewers(ctx context.Context, obj *model.Post) (int, error) {
+	return r.SubscriptionService.Viewers(obj.ID), nil
+}
+
 // Posts is the resolver for the posts field.
 func (r *queryResolver) Posts(ctx context.Context) ([]*model.Post, error) {
 	posts, err := r.PostService.GetPosts(ctx)
@@ -94,16 +142,66 @@ func (r *queryResolver) Post(ctx context.Context, postID int64) (*model.Post, er
 }
 
 // CommentAdded is the resolver for the commentAdded field.
-func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID int64) (<-chan *model.Comment, error) {
-	ch := make(chan *model.Comment, 1)
-	r.SubscriptionService.Subscribe(postID, ch)
+func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID int64, since *int64) (<-chan *model.Comment, error) {
+	var sub *subscription.Subscription
+	var err error
+	if since != nil {
+		if *since < 0 {
+			return nil, fmt.Errorf("since cannot be negative")
+		}
+		sub, err = r.SubscriptionService.SubscribeSince(ctx, postID, *since, r.CommentService.GetCommentsAfter)
+	} else {
+		sub, err = r.SubscriptionService.Subscribe(ctx, postID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to comments: %w", err)
+	}
+	return stream(ctx, sub, commentOf), nil
+}
+
+// PostActivity is the resolver for the postActivity field.
+func (r *subscriptionResolver) PostActivity(ctx context.Context, postID int64) (<-chan model.PostActivity, error) {
+	sub, err := r.SubscriptionService.SubscribePostActivity(ctx, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to post activity: %w", err)
+	}
+	return stream(ctx, sub, postActivity), nil
+}
+
+// PostCreated is the resolver for the postCreated field.
+func (r *subscriptionResolver) PostCreated(ctx context.Context) (<-chan *model.Post, error) {
+	sub, err := r.SubscriptionService.SubscribePostsCreated(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to new posts: %w", err)
+	}
+	return stream(ctx, sub, postOf), nil
+}
 
-	go func() {
-		<-ctx.Done()
-		r.SubscriptionService.Unsubscribe(postID, ch)
-	}()
+// RepliesAdded is the resolver for the repliesAdded field.
+func (r *subscriptionResolver) RepliesAdded(ctx context.Context, commentID int64) (<-chan *model.Comment, error) {
+	sub, err := r.SubscriptionService.SubscribeReplies(ctx, commentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to replies: %w", err)
+	}
+	return stream(ctx, sub, commentOf), nil
+}
 
-	return ch, nil
+// CommentsByAuthor is the resolver for the commentsByAuthor field.
+func (r *subscriptionResolver) CommentsByAuthor(ctx context.Context, authorID uuid.UUID) (<-chan *model.Comment, error) {
+	sub, err := r.SubscriptionService.SubscribeAuthor(ctx, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to author comments: %w", err)
+	}
+	return stream(ctx, sub, commentOf), nil
+}
+
+// ViewersChanged is the resolver for the viewersChanged field.
+func (r *subscriptionResolver) ViewersChanged(ctx context.Context, postID int64) (<-chan *model.Presence, error) {
+	sub, err := r.SubscriptionService.SubscribeViewers(ctx, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to viewers: %w", err)
+	}
+	return stream(ctx, sub, presenceOf), nil
 }
 
 // Comment returns CommentResolver implementation.
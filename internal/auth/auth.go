@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+)
+
+// accessTokenParam carries the token for clients like EventSource that
+// can't set request headers.
+const accessTokenParam = "access_token"
+
+type authenticatedKey struct{}
+
+// Tokens authenticates subscribers by bearer token. With no tokens
+// configured every client counts as authenticated.
+type Tokens struct {
+	tokens [][]byte
+}
+
+func NewTokens(tokens []string) *Tokens {
+	t := &Tokens{}
+	for _, token := range tokens {
+		if token != "" {
+			t.tokens = append(t.tokens, []byte(token))
+		}
+	}
+	return t
+}
+
+// Check reports whether credentials, a token optionally prefixed with
+// "Bearer ", is one of the configured tokens.
+func (t *Tokens) Check(credentials string) bool {
+	if len(t.tokens) == 0 {
+		return true
+	}
+	token := []byte(strings.TrimSpace(strings.TrimPrefix(credentials, "Bearer ")))
+	ok := false
+	for _, want := range t.tokens {
+		if subtle.ConstantTimeCompare(token, want) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Middleware marks requests that carry a valid token in the Authorization
+// header or the access_token query parameter as authenticated. It rejects
+// nothing itself: only subscriptions require authentication.
+func (t *Tokens) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(t.fromRequest(r)))
+	})
+}
+
+// Require is Middleware for endpoints that only serve subscribers: requests
+// without a valid token get 401.
+func (t *Tokens) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.fromRequest(r)
+		if !Authenticated(ctx) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, errs.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (t *Tokens) fromRequest(r *http.Request) context.Context {
+	credentials := r.Header.Get("Authorization")
+	if credentials == "" {
+		credentials = r.URL.Query().Get(accessTokenParam)
+	}
+	if !t.Check(credentials) {
+		return r.Context()
+	}
+	return context.WithValue(r.Context(), authenticatedKey{}, true)
+}
+
+// FromInitPayload authenticates a websocket connection by the Authorization
+// value of its connection_init payload, unless the upgrade request already
+// carried a valid token.
+func (t *Tokens) FromInitPayload(ctx context.Context, payload transport.InitPayload) (context.Context, error) {
+	if Authenticated(ctx) {
+		return ctx, nil
+	}
+	if !t.Check(payload.Authorization()) {
+		return ctx, errs.ErrUnauthorized
+	}
+	return context.WithValue(ctx, authenticatedKey{}, true), nil
+}
+
+// Authenticated reports whether ctx belongs to an authenticated client.
+func Authenticated(ctx context.Context) bool {
+	ok, _ := ctx.Value(authenticatedKey{}).(bool)
+	return ok
+}
@@ -0,0 +1,81 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/auth"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	tokens := auth.NewTokens([]string{"s3cr3t", "other"})
+	assert.True(t, tokens.Check("s3cr3t"))
+	assert.True(t, tokens.Check("Bearer other"))
+	assert.False(t, tokens.Check("Bearer wrong"))
+	assert.False(t, tokens.Check(""))
+
+	assert.True(t, auth.NewTokens(nil).Check(""), "no tokens configured lets everyone in")
+}
+
+func TestMiddlewareMarksAuthenticatedRequests(t *testing.T) {
+	tokens := auth.NewTokens([]string{"s3cr3t"})
+	var authenticated bool
+	h := tokens.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated = auth.Authenticated(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   bool
+	}{
+		{name: "header", target: "/query", header: "Bearer s3cr3t", want: true},
+		{name: "query parameter", target: "/query?access_token=s3cr3t", want: true},
+		{name: "wrong token", target: "/query", header: "Bearer nope", want: false},
+		{name: "no token", target: "/query", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.want, authenticated)
+		})
+	}
+}
+
+func TestRequireRejectsMissingToken(t *testing.T) {
+	tokens := auth.NewTokens([]string{"s3cr3t"})
+	h := tokens.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events/posts/1", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events/posts/1?access_token=s3cr3t", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestFromInitPayload(t *testing.T) {
+	tokens := auth.NewTokens([]string{"s3cr3t"})
+
+	ctx, err := tokens.FromInitPayload(context.Background(), transport.InitPayload{"Authorization": "Bearer s3cr3t"})
+	require.NoError(t, err)
+	assert.True(t, auth.Authenticated(ctx))
+
+	_, err = tokens.FromInitPayload(context.Background(), transport.InitPayload{"authorization": "Bearer nope"})
+	assert.ErrorIs(t, err, errs.ErrUnauthorized)
+}
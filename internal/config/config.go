@@ -3,53 +3,196 @@ package config
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"time"
 
-	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/tracing"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/kelseyhightower/envconfig"
-	"go.uber.org/zap"
+	"github.com/redis/go-redis/v9"
 )
 
+// Config is filled by Load. Every leaf field is read from the environment
+// variable named in its envconfig tag; the same field can be set in the
+// config file under its snake_case path, e.g. database.host, unless a file
+// tag names it explicitly.
 type Config struct {
 	Server struct {
-		Port string `envconfig:"SERVER_PORT" `
-		Host string `envconfig:"SERVER_HOST"`
-	} `envconfig:"SERVER"`
+		Port              string        `envconfig:"SERVER_PORT" default:"8080" required:"true"`
+		Host              string        `envconfig:"SERVER_HOST"`
+		TrustProxyHeaders bool          `envconfig:"TRUST_PROXY_HEADERS" default:"false"`
+		ShutdownTimeout   time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"15s" min:"1s"`
+	}
+	Storage struct {
+		Type string `envconfig:"STORAGE_TYPE" default:"db" required:"true"`
+	}
 	Database struct {
 		Host     string `envconfig:"DB_HOST"`
-		Port     string `envconfig:"DB_PORT" `
-		Name     string `envconfig:"DB_NAME" `
-		User     string `envconfig:"DB_USER" `
-		Password string `envconfig:"DB_PASSWORD"`
-		SSLMode  string `envconfig:"DB_SSLMODE"`
-	} `envconfig:"DATABASE"`
+		Port     string `envconfig:"DB_PORT" default:"5432"`
+		Name     string `envconfig:"DB_NAME"`
+		User     string `envconfig:"DB_USER"`
+		Password string `envconfig:"DB_PASSWORD" secret:"true"`
+		SSLMode  string `envconfig:"DB_SSL" default:"disable" oneof:"disable allow prefer require verify-ca verify-full"`
+		// AutoMigrate applies pending migrations on startup instead of
+		// refusing to start against an outdated schema.
+		AutoMigrate bool `envconfig:"DB_AUTO_MIGRATE" default:"false"`
+	}
+	Log struct {
+		Level         string            `envconfig:"LOG_LEVEL" default:"info" oneof:"debug info warn error dpanic panic fatal"`
+		PackageLevels map[string]string `envconfig:"LOG_PACKAGE_LEVELS"`
+		// AdminEnabled serves /admin/log/level to clients presenting one
+		// of AdminTokens.
+		AdminEnabled bool     `envconfig:"LOG_ADMIN_ENABLED" default:"false"`
+		AdminTokens  []string `envconfig:"LOG_ADMIN_TOKENS" secret:"true"`
+		Sampling     struct {
+			Initial    int           `envconfig:"LOG_SAMPLING_INITIAL" default:"100" min:"0"`
+			Thereafter int           `envconfig:"LOG_SAMPLING_THEREAFTER" default:"100" min:"0"`
+			Tick       time.Duration `envconfig:"LOG_SAMPLING_TICK" default:"1s" min:"1ms"`
+		}
+		Redaction struct {
+			Content string `envconfig:"LOG_REDACT_CONTENT" default:"mask" oneof:"mask hash none"`
+			IDs     string `envconfig:"LOG_REDACT_IDS" default:"hash" oneof:"mask hash none"`
+		}
+	}
+	GraphQL struct {
+		MaxDepth      int `envconfig:"GRAPHQL_MAX_DEPTH" default:"10" min:"1"`
+		MaxComplexity int `envconfig:"GRAPHQL_MAX_COMPLEXITY" default:"10000" min:"1"`
+	} `file:"graphql"`
+	APQ struct {
+		Enabled   bool          `envconfig:"APQ_ENABLED" default:"true"`
+		Cache     string        `envconfig:"APQ_CACHE" default:"lru" oneof:"lru redis"`
+		CacheSize int           `envconfig:"APQ_CACHE_SIZE" default:"1000" min:"1"`
+		CacheTTL  time.Duration `envconfig:"APQ_CACHE_TTL" default:"24h" min:"1s"`
+	}
+	PersistedQueries struct {
+		Manifest string `envconfig:"PERSISTED_QUERIES_MANIFEST"`
+		Strict   bool   `envconfig:"PERSISTED_QUERIES_STRICT" default:"false"`
+	}
+	RateLimit struct {
+		Enabled bool              `envconfig:"RATE_LIMIT_ENABLED" default:"true"`
+		Backend string            `envconfig:"RATE_LIMIT_BACKEND" default:"memory" oneof:"memory redis"`
+		Rules   map[string]string `envconfig:"RATE_LIMIT_RULES" default:"createPost:10/1m,createComment:30/1m,updateAllowComments:30/1m,updatePost:30/1m,editComment:30/1m,deleteComment:30/1m,commentAdded:30/1m,postActivity:30/1m,postCreated:30/1m,repliesAdded:30/1m,commentsByAuthor:30/1m,setTyping:60/1m,viewersChanged:30/1m"`
+	}
+	Subscriptions struct {
+		// Broker carries events between instances; auto picks
+		// postgres for STORAGE_TYPE=db and memory otherwise. memory can't
+		// be used with STORAGE_TYPE=db.
+		Broker string `envconfig:"SUBSCRIPTION_BROKER" default:"auto" oneof:"auto memory postgres redis"`
+		// Buffer is the number of comments queued per subscriber before
+		// SlowPolicy applies.
+		Buffer       int           `envconfig:"SUBSCRIPTION_BUFFER" default:"64" min:"1"`
+		SlowPolicy   string        `envconfig:"SUBSCRIPTION_SLOW_POLICY" default:"drop-oldest" oneof:"drop-oldest disconnect block"`
+		BlockTimeout time.Duration `envconfig:"SUBSCRIPTION_BLOCK_TIMEOUT" default:"1s" min:"1ms"`
+		// TypingTTL is how long a typing indicator lasts unless it is set
+		// again.
+		TypingTTL time.Duration `envconfig:"SUBSCRIPTION_TYPING_TTL" default:"5s" min:"1s"`
+		// KeepAlive is how often idle websockets and event streams get a
+		// keep-alive message.
+		KeepAlive time.Duration `envconfig:"SUBSCRIPTION_KEEPALIVE" default:"15s" min:"1s"`
+		// AuthTokens, when set, are the bearer tokens a client must present
+		// to subscribe.
+		AuthTokens []string `envconfig:"SUBSCRIPTION_AUTH_TOKENS" secret:"true"`
+		// MaxConnectionsPerIP caps the websockets and event streams one
+		// client IP keeps open; 0 disables the limit.
+		MaxConnectionsPerIP int `envconfig:"SUBSCRIPTION_MAX_CONNECTIONS_PER_IP" default:"20" min:"0"`
+	}
+	Websocket struct {
+		// AllowedOrigins lists the origins pages may open websockets from;
+		// when empty only the server's own host is allowed, * allows any.
+		AllowedOrigins []string      `envconfig:"WS_ALLOWED_ORIGINS"`
+		InitTimeout    time.Duration `envconfig:"WS_INIT_TIMEOUT" default:"10s" min:"1s"`
+		// MaxSubscriptions caps the subscriptions running at once on one
+		// connection; 0 disables the limit.
+		MaxSubscriptions int `envconfig:"WS_MAX_SUBSCRIPTIONS_PER_CONNECTION" default:"50" min:"0"`
+	}
+	Outbox struct {
+		// PollInterval is how often the outbox is checked for events stored
+		// by other instances or whose delivery failed.
+		PollInterval time.Duration `envconfig:"OUTBOX_POLL_INTERVAL" default:"1s" min:"10ms"`
+		BatchSize    int           `envconfig:"OUTBOX_BATCH_SIZE" default:"100" min:"1"`
+		// Retention is how long delivered events are kept.
+		Retention time.Duration `envconfig:"OUTBOX_RETENTION" default:"24h" min:"1m"`
+		// MaxAttempts is how many times an event is tried before it is
+		// parked; the retries back off up to MaxBackoff.
+		MaxAttempts int           `envconfig:"OUTBOX_MAX_ATTEMPTS" default:"10" min:"1"`
+		MaxBackoff  time.Duration `envconfig:"OUTBOX_MAX_BACKOFF" default:"5m" min:"10ms"`
+	}
+	Idempotency struct {
+		TTL time.Duration `envconfig:"IDEMPOTENCY_TTL" default:"24h" min:"1m"`
+	}
+	Metrics struct {
+		Enabled bool `envconfig:"METRICS_ENABLED" default:"true"`
+		// OperationNames are the operation names used as metric labels,
+		// besides those of the persisted query manifest; other names are
+		// recorded as "other".
+		OperationNames []string `envconfig:"METRICS_OPERATION_NAMES"`
+	}
+	Tracing struct {
+		Exporter     string  `envconfig:"TRACING_EXPORTER" default:"none" oneof:"none stdout otlp"`
+		OTLPEndpoint string  `envconfig:"OTLP_ENDPOINT" default:"localhost:4318"`
+		OTLPInsecure bool    `envconfig:"OTLP_INSECURE" default:"true"`
+		SampleRatio  float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1" min:"0" max:"1"`
+		ServiceName  string  `envconfig:"TRACING_SERVICE_NAME" default:"ozon-posts" required:"true"`
+	}
+	Redis struct {
+		Addr     string `envconfig:"REDIS_ADDR" default:"localhost:6379"`
+		Password string `envconfig:"REDIS_PASSWORD" secret:"true"`
+		DB       int    `envconfig:"REDIS_DB" default:"0" min:"0" max:"15"`
+	}
 }
 
-func NewConfig() (*Config, error) {
-	var cfg Config
-	err := envconfig.Process("", &cfg)
-	if err != nil {
-		log.Error("Failed to load configuration from environment variables", zap.Error(err))
+// SubscriptionBroker returns the broker to use, with auto resolved.
+func (c *Config) SubscriptionBroker() string {
+	if c.Subscriptions.Broker != "auto" {
+		return c.Subscriptions.Broker
+	}
+	if c.Storage.Type == "db" {
+		return "postgres"
+	}
+	return "memory"
+}
+
+// DatabaseURL returns the connection string for the configured database.
+func (c *Config) DatabaseURL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Database.User, c.Database.Password),
+		Host:     c.Database.Host + ":" + c.Database.Port,
+		Path:     "/" + c.Database.Name,
+		RawQuery: url.Values{"sslmode": {c.Database.SSLMode}}.Encode(),
 	}
-	return &cfg, nil
+	return u.String()
 }
 
-func (c *Config) ConnectDatabase(ctx context.Context, cfg *Config) (*pgxpool.Pool, error) {
+func (c *Config) ConnectDatabase(ctx context.Context) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", cfg.Database.User, cfg.Database.Password, cfg.Database.Host, cfg.Database.Port, cfg.Database.Name, cfg.Database.SSLMode)
-	poolConfig, err := pgxpool.ParseConfig(dsn)
+	poolConfig, err := pgxpool.ParseConfig(c.DatabaseURL())
 	if err != nil {
-		log.Error("Failed to parse database connection string", zap.Error(err))
+		return nil, fmt.Errorf("failed to parse database connection string: %w", err)
 	}
+	poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
 	dbpool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		log.Error("Failed to create database connection pool", zap.Error(err))
+		return nil, fmt.Errorf("failed to create database connection pool: %w", err)
 	}
-	err = dbpool.Ping(ctx)
-	if err != nil {
-		log.Error("Failed to ping database", zap.Error(err))
+	if err := dbpool.Ping(ctx); err != nil {
+		dbpool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 	return dbpool, nil
 }
+
+func (c *Config) ConnectRedis(ctx context.Context) (*redis.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	client := redis.NewClient(&redis.Options{
+		Addr:     c.Redis.Addr,
+		Password: c.Redis.Password,
+		DB:       c.Redis.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+	return client, nil
+}
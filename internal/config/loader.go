@@ -0,0 +1,292 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, in increasing priority: the defaults
+// in struct tags, the optional YAML or TOML file at path, environment
+// variables and, for secrets, files named by <VAR>_FILE. All invalid values
+// are reported together.
+func Load(path string) (*Config, error) {
+	tree, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	var errs []error
+	consumed := map[string]bool{}
+	for _, f := range fields(cfg) {
+		consumed[f.path] = true
+		raw, ok, err := f.lookup(tree)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := f.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.key, err))
+		}
+	}
+	errs = append(errs, unknownKeys(tree, "", consumed)...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func readFile(path string) (map[string]any, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	tree := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return tree, nil
+}
+
+// field is a leaf of Config: its environment variable, its path in the
+// config file and the value it is stored in.
+type field struct {
+	key   string
+	path  string
+	tag   reflect.StructTag
+	value reflect.Value
+}
+
+func (f field) secret() bool {
+	return f.tag.Get("secret") == "true"
+}
+
+func fields(cfg *Config) []field {
+	var out []field
+	collect(reflect.ValueOf(cfg).Elem(), "", &out)
+	return out
+}
+
+func collect(v reflect.Value, prefix string, out *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := snakeCase(sf.Name)
+		if name := sf.Tag.Get("file"); name != "" {
+			path = name
+		}
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if key := sf.Tag.Get("envconfig"); key != "" {
+			*out = append(*out, field{key: key, path: path, tag: sf.Tag, value: v.Field(i)})
+			continue
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			collect(v.Field(i), path, out)
+		}
+	}
+}
+
+// lookup returns the raw value with the highest priority. Empty environment
+// variables, as left by .env templates, count as unset.
+func (f field) lookup(tree map[string]any) (string, bool, error) {
+	raw, ok := f.tag.Lookup("default")
+	if v, found := fileValue(tree, f.path); found {
+		raw, ok = v, true
+	}
+	if v := os.Getenv(f.key); v != "" {
+		raw, ok = v, true
+	}
+	if !f.secret() {
+		return raw, ok, nil
+	}
+	file := os.Getenv(f.key + "_FILE")
+	if file == "" {
+		return raw, ok, nil
+	}
+	if os.Getenv(f.key) != "" {
+		return "", false, fmt.Errorf("%s and %s_FILE are both set", f.key, f.key)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", f.key, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func fileValue(tree map[string]any, path string) (string, bool) {
+	var node any = tree
+	for _, part := range strings.Split(path, ".") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if node, ok = m[part]; !ok {
+			return "", false
+		}
+	}
+	switch node := node.(type) {
+	case map[string]any:
+		return formatMap(node), true
+	case []any:
+		items := make([]string, len(node))
+		for i, item := range node {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ","), true
+	}
+	return fmt.Sprint(node), true
+}
+
+func unknownKeys(node map[string]any, prefix string, consumed map[string]bool) []error {
+	var errs []error
+	for key, value := range node {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if consumed[path] {
+			continue
+		}
+		if child, ok := value.(map[string]any); ok {
+			errs = append(errs, unknownKeys(child, path, consumed)...)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("unknown config file key %q", path))
+	}
+	return errs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (f field) set(raw string) error {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(raw, 10, 0)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case v.Kind() == reflect.Map:
+		m, err := parseMap(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(m))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(parseList(raw)))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// parseMap reads the "key:value,key:value" form used by envconfig.
+func parseMap(raw string) (map[string]string, error) {
+	m := map[string]string{}
+	if strings.TrimSpace(raw) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid map entry %q, expected key:value", pair)
+		}
+		m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return m, nil
+}
+
+// parseList reads a comma-separated list, skipping empty items.
+func parseList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func formatMap[V any](m map[string]V) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s:%v", k, m[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// snakeCase turns Go field names into config file keys: MaxDepth becomes
+// max_depth and OTLPEndpoint becomes otlp_endpoint.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1]) && !(i+2 == len(runes) && runes[i+1] == 's')) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+const maskedSecret = "******"
+
+// Print writes the effective configuration as KEY=value lines, in the same
+// form as .env, with secrets masked.
+func (c *Config) Print(w io.Writer) error {
+	for _, f := range fields(c) {
+		value := formatValue(f.value)
+		if f.secret() && value != "" {
+			value = maskedSecret
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", f.key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Map {
+		return formatMap(v.Interface().(map[string]string))
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
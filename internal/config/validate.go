@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/ratelimit"
+)
+
+// Validate checks the required, oneof, min and max tags and the rules that
+// span several fields, returning every violation at once.
+func (c *Config) Validate() error {
+	var errs []error
+	for _, f := range fields(c) {
+		errs = append(errs, f.validate()...)
+	}
+
+	if c.Storage.Type == "db" {
+		for _, required := range []struct{ key, value string }{
+			{"DB_HOST", c.Database.Host},
+			{"DB_NAME", c.Database.Name},
+			{"DB_USER", c.Database.User},
+		} {
+			if required.value == "" {
+				errs = append(errs, fmt.Errorf("%s is required when STORAGE_TYPE is db", required.key))
+			}
+		}
+	}
+	if c.Subscriptions.Broker == "postgres" && c.Storage.Type != "db" {
+		errs = append(errs, errors.New("SUBSCRIPTION_BROKER=postgres requires STORAGE_TYPE=db"))
+	}
+	// Events of the shared outbox are relayed by whichever instance holds
+	// it, so they have to reach the other instances through the broker.
+	if c.Subscriptions.Broker == "memory" && c.Storage.Type == "db" {
+		errs = append(errs, errors.New("SUBSCRIPTION_BROKER=memory requires STORAGE_TYPE=memory"))
+	}
+	if c.Log.AdminEnabled && len(c.Log.AdminTokens) == 0 {
+		errs = append(errs, errors.New("LOG_ADMIN_ENABLED requires LOG_ADMIN_TOKENS"))
+	}
+	if c.PersistedQueries.Strict && c.PersistedQueries.Manifest == "" {
+		errs = append(errs, errors.New("PERSISTED_QUERIES_STRICT requires PERSISTED_QUERIES_MANIFEST"))
+	}
+	if c.RateLimit.Enabled {
+		if _, err := ratelimit.ParseRules(c.RateLimit.Rules); err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_RULES: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f field) validate() []error {
+	var errs []error
+	if f.tag.Get("required") == "true" && f.value.IsZero() {
+		errs = append(errs, fmt.Errorf("%s is required", f.key))
+	}
+	if oneof := f.tag.Get("oneof"); oneof != "" && f.value.Kind() == reflect.String {
+		allowed := strings.Fields(oneof)
+		if !contains(allowed, f.value.String()) {
+			errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", f.key, strings.Join(allowed, ", "), f.value.String()))
+		}
+	}
+	for _, bound := range []string{"min", "max"} {
+		limit, ok := f.tag.Lookup(bound)
+		if !ok {
+			continue
+		}
+		value, limitValue, err := f.compareValues(limit)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid %s tag: %w", f.key, bound, err))
+			continue
+		}
+		if bound == "min" && value < limitValue {
+			errs = append(errs, fmt.Errorf("%s must be at least %s", f.key, limit))
+		}
+		if bound == "max" && value > limitValue {
+			errs = append(errs, fmt.Errorf("%s must be at most %s", f.key, limit))
+		}
+	}
+	return errs
+}
+
+func (f field) compareValues(limit string) (float64, float64, error) {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(limit)
+		return float64(f.value.Int()), float64(d), err
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.ParseFloat(limit, 64)
+		return float64(f.value.Int()), n, err
+	case f.value.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(limit, 64)
+		return f.value.Float(), n, err
+	}
+	return 0, 0, fmt.Errorf("not a number")
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package gqlext
+
+import (
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+)
+
+// authorFromArgs returns the acting author of a root mutation, if any of its
+// arguments carries one.
+func authorFromArgs(fc *graphql.FieldContext) (uuid.UUID, bool) {
+	for _, arg := range fc.Args {
+		switch v := arg.(type) {
+		case model.NewPost:
+			return v.AuthorID, true
+		case model.NewComment:
+			return v.AuthorID, true
+		case uuid.UUID:
+			return v, true
+		}
+	}
+	return uuid.Nil, false
+}
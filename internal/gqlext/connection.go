@@ -0,0 +1,112 @@
+package gqlext
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/auth"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errUnauthenticated         = "UNAUTHENTICATED"
+	errTooManySubscriptions    = "TOO_MANY_SUBSCRIPTIONS"
+	subscriptionAuthExtension  = "SubscriptionAuth"
+	subscriptionLimitExtension = "SubscriptionLimit"
+)
+
+// SubscriptionAuth rejects subscriptions from clients that auth did not
+// authenticate, whatever transport they arrive on.
+type SubscriptionAuth struct{}
+
+var _ interface {
+	graphql.OperationInterceptor
+	graphql.HandlerExtension
+} = SubscriptionAuth{}
+
+func (SubscriptionAuth) ExtensionName() string {
+	return subscriptionAuthExtension
+}
+
+func (SubscriptionAuth) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (SubscriptionAuth) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	if !isSubscription(ctx) || auth.Authenticated(ctx) {
+		return next(ctx)
+	}
+	return operationError(errs.ErrUnauthorized.Error(), errUnauthenticated)
+}
+
+type connectionKey struct{}
+
+type connection struct {
+	mu            sync.Mutex
+	subscriptions int
+}
+
+// TrackConnection marks ctx as the context of one websocket connection, so
+// that SubscriptionLimit counts the subscriptions started on it.
+func TrackConnection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, connectionKey{}, &connection{})
+}
+
+// SubscriptionLimit caps the subscriptions running at once on a connection
+// marked by TrackConnection. Operations from other transports aren't
+// counted.
+type SubscriptionLimit struct {
+	Max int
+}
+
+var _ interface {
+	graphql.OperationInterceptor
+	graphql.HandlerExtension
+} = SubscriptionLimit{}
+
+func (SubscriptionLimit) ExtensionName() string {
+	return subscriptionLimitExtension
+}
+
+func (SubscriptionLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (l SubscriptionLimit) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	conn, ok := ctx.Value(connectionKey{}).(*connection)
+	if !ok || l.Max <= 0 || !isSubscription(ctx) {
+		return next(ctx)
+	}
+
+	conn.mu.Lock()
+	if conn.subscriptions >= l.Max {
+		conn.mu.Unlock()
+		return operationError(fmt.Sprintf("at most %d subscriptions are allowed per connection", l.Max), errTooManySubscriptions)
+	}
+	conn.subscriptions++
+	conn.mu.Unlock()
+
+	// The transport cancels the operation's context once it has ended.
+	context.AfterFunc(ctx, func() {
+		conn.mu.Lock()
+		conn.subscriptions--
+		conn.mu.Unlock()
+	})
+	return next(ctx)
+}
+
+func isSubscription(ctx context.Context) bool {
+	opCtx := graphql.GetOperationContext(ctx)
+	return opCtx.Operation != nil && opCtx.Operation.Operation == ast.Subscription
+}
+
+func operationError(message, code string) graphql.ResponseHandler {
+	return graphql.OneShot(&graphql.Response{Errors: gqlerror.List{{
+		Message:    message,
+		Extensions: map[string]any{"code": code},
+	}}})
+}
@@ -0,0 +1,75 @@
+package gqlext_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/auth"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func withOperation(ctx context.Context, op ast.Operation) context.Context {
+	return graphql.WithOperationContext(ctx, &graphql.OperationContext{
+		Operation: &ast.OperationDefinition{Operation: op},
+	})
+}
+
+// run passes ctx through ext and returns the error code of the response,
+// or "" if the operation was executed.
+func run(ext graphql.OperationInterceptor, ctx context.Context) string {
+	handler := ext.InterceptOperation(ctx, func(ctx context.Context) graphql.ResponseHandler {
+		return graphql.OneShot(&graphql.Response{})
+	})
+	resp := handler(ctx)
+	if len(resp.Errors) == 0 {
+		return ""
+	}
+	code, _ := resp.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func authenticatedContext(t *testing.T) context.Context {
+	var ctx context.Context
+	h := auth.NewTokens(nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/query", nil))
+	require.True(t, auth.Authenticated(ctx))
+	return ctx
+}
+
+func TestSubscriptionAuth_RejectsUnauthenticatedSubscriptions(t *testing.T) {
+	ext := gqlext.SubscriptionAuth{}
+
+	assert.Equal(t, "UNAUTHENTICATED", run(ext, withOperation(context.Background(), ast.Subscription)))
+	assert.Equal(t, "", run(ext, withOperation(context.Background(), ast.Mutation)), "only subscriptions require authentication")
+	assert.Equal(t, "", run(ext, withOperation(authenticatedContext(t), ast.Subscription)))
+}
+
+func TestSubscriptionLimit_CountsRunningSubscriptionsPerConnection(t *testing.T) {
+	ext := gqlext.SubscriptionLimit{Max: 2}
+	conn := gqlext.TrackConnection(context.Background())
+
+	var cancels []context.CancelFunc
+	for range 2 {
+		ctx, cancel := context.WithCancel(conn)
+		cancels = append(cancels, cancel)
+		require.Equal(t, "", run(ext, withOperation(ctx, ast.Subscription)))
+	}
+	assert.Equal(t, "TOO_MANY_SUBSCRIPTIONS", run(ext, withOperation(conn, ast.Subscription)))
+	assert.Equal(t, "", run(ext, withOperation(conn, ast.Query)), "queries are not counted")
+	assert.Equal(t, "", run(ext, withOperation(gqlext.TrackConnection(context.Background()), ast.Subscription)),
+		"other connections have their own limit")
+
+	cancels[0]()
+	assert.Eventually(t, func() bool {
+		return run(ext, withOperation(conn, ast.Subscription)) == ""
+	}, time.Second, 10*time.Millisecond, "an ended subscription frees its slot")
+}
@@ -0,0 +1,82 @@
+package gqlext
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errDepthLimit  = "DEPTH_LIMIT_EXCEEDED"
+	depthExtension = "DepthLimit"
+)
+
+// DepthLimit rejects operations whose selections nest deeper than MaxDepth
+// before any resolver runs. Introspection fields are not counted.
+type DepthLimit struct {
+	MaxDepth int
+}
+
+var _ interface {
+	graphql.OperationContextMutator
+	graphql.HandlerExtension
+} = DepthLimit{}
+
+func (d DepthLimit) ExtensionName() string {
+	return depthExtension
+}
+
+func (d DepthLimit) Validate(schema graphql.ExecutableSchema) error {
+	if d.MaxDepth <= 0 {
+		return errors.New("DepthLimit max depth must be positive")
+	}
+	return nil
+}
+
+func (d DepthLimit) MutateOperationContext(ctx context.Context, opCtx *graphql.OperationContext) *gqlerror.Error {
+	op := opCtx.Doc.Operations.ForName(opCtx.OperationName)
+	if op == nil {
+		return nil
+	}
+
+	depth := selectionSetDepth(opCtx.Doc, op.SelectionSet)
+	if depth > d.MaxDepth {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", depth, d.MaxDepth)
+		errcode.Set(err, errDepthLimit)
+		return err
+	}
+	return nil
+}
+
+func selectionSetDepth(doc *ast.QueryDocument, set ast.SelectionSet) int {
+	maxDepth := 0
+	for _, selection := range set {
+		var depth int
+		switch s := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name, "__") {
+				continue
+			}
+			depth = 1 + selectionSetDepth(doc, s.SelectionSet)
+		case *ast.InlineFragment:
+			depth = selectionSetDepth(doc, s.SelectionSet)
+		case *ast.FragmentSpread:
+			def := s.Definition
+			if def == nil {
+				def = doc.Fragments.ForName(s.Name)
+			}
+			if def != nil {
+				depth = selectionSetDepth(doc, def.SelectionSet)
+			}
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	return maxDepth
+}
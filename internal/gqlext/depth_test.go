@@ -0,0 +1,55 @@
+package gqlext_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+func operationContext(t *testing.T, query string) *graphql.OperationContext {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	require.Nil(t, err)
+	return &graphql.OperationContext{Doc: doc}
+}
+
+func TestDepthLimit_AllowsShallowQuery(t *testing.T) {
+	ext := gqlext.DepthLimit{MaxDepth: 3}
+	opCtx := operationContext(t, `{ posts { comments { id } } }`)
+
+	assert.Nil(t, ext.MutateOperationContext(context.Background(), opCtx))
+}
+
+func TestDepthLimit_RejectsNestedReplies(t *testing.T) {
+	ext := gqlext.DepthLimit{MaxDepth: 3}
+	opCtx := operationContext(t, `{ posts { comments { replies { replies { id } } } } }`)
+
+	err := ext.MutateOperationContext(context.Background(), opCtx)
+	require.NotNil(t, err)
+	assert.Equal(t, "operation has depth 5, which exceeds the limit of 3", err.Message)
+	assert.Equal(t, "DEPTH_LIMIT_EXCEEDED", err.Extensions["code"])
+}
+
+func TestDepthLimit_FollowsFragments(t *testing.T) {
+	ext := gqlext.DepthLimit{MaxDepth: 3}
+	opCtx := operationContext(t, `
+		query { posts { ...PostFields } }
+		fragment PostFields on Post { comments { ... on Comment { replies { id } } } }
+	`)
+
+	err := ext.MutateOperationContext(context.Background(), opCtx)
+	require.NotNil(t, err)
+	assert.Equal(t, "operation has depth 4, which exceeds the limit of 3", err.Message)
+}
+
+func TestDepthLimit_IgnoresIntrospection(t *testing.T) {
+	ext := gqlext.DepthLimit{MaxDepth: 2}
+	opCtx := operationContext(t, `{ __schema { types { fields { type { name } } } } posts { id } }`)
+
+	assert.Nil(t, ext.MutateOperationContext(context.Background(), opCtx))
+}
@@ -0,0 +1,49 @@
+package gqlext
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"go.uber.org/zap"
+)
+
+// RequestLogger adds the operation name and, for mutations and
+// subscriptions, the acting author to the request-scoped logger.
+type RequestLogger struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+	graphql.FieldInterceptor
+} = RequestLogger{}
+
+func (RequestLogger) ExtensionName() string {
+	return "RequestLogger"
+}
+
+func (RequestLogger) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (RequestLogger) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	opCtx := graphql.GetOperationContext(ctx)
+	if opCtx.Operation == nil {
+		return next(ctx)
+	}
+	return next(log.With(ctx,
+		zap.String("operation", opCtx.Operation.Name),
+		zap.String("operation_type", string(opCtx.Operation.Operation)),
+	))
+}
+
+func (RequestLogger) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || (fc.Object != "Mutation" && fc.Object != "Subscription") {
+		return next(ctx)
+	}
+	if author, ok := authorFromArgs(fc); ok {
+		ctx = log.With(ctx, zap.String("principal", author.String()))
+	}
+	return next(ctx)
+}
@@ -0,0 +1,47 @@
+package gqlext_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogger_AddsPrincipalToMutations(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	author := uuid.New()
+
+	ctx := fieldContext("Mutation", "createPost", map[string]any{
+		"postInput": model.NewPost{AuthorID: author, Title: "t", Content: "c"},
+	})
+	ctx = log.WithLogger(ctx, zap.New(core))
+	_, err := gqlext.RequestLogger{}.InterceptField(ctx, func(ctx context.Context) (any, error) {
+		log.FromContext(ctx).Info("resolved")
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, author.String(), logs.All()[0].ContextMap()["principal"])
+}
+
+func TestRequestLogger_IgnoresQueryFields(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+
+	ctx := log.WithLogger(fieldContext("Query", "posts", nil), zap.New(core))
+	_, err := gqlext.RequestLogger{}.InterceptField(ctx, func(ctx context.Context) (any, error) {
+		log.FromContext(ctx).Info("resolved")
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, logs.Len())
+	assert.NotContains(t, logs.All()[0].ContextMap(), "principal")
+}
@@ -0,0 +1,129 @@
+package gqlext
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+const (
+	errPersistedQueryNotAllowed = "PERSISTED_QUERY_NOT_ALLOWED"
+	persistedQueryExtension     = "PersistedQueryAllowlist"
+)
+
+// PersistedQueryAllowlist serves queries from a pre-registered manifest of
+// sha256 hash -> document. It must be registered before
+// extension.AutomaticPersistedQuery so manifest hits never reach the APQ cache
+// as misses. In Strict mode every operation that is not in the manifest is
+// rejected, whether it was sent by hash or as a full document.
+type PersistedQueryAllowlist struct {
+	Manifest map[string]string
+	Strict   bool
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = PersistedQueryAllowlist{}
+
+// LoadPersistedQueryManifest reads a JSON object mapping sha256 hashes to
+// query documents and verifies every hash against its document.
+func LoadPersistedQueryManifest(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persisted query manifest: %w", err)
+	}
+
+	var manifest map[string]string
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse persisted query manifest: %w", err)
+	}
+
+	for hash, query := range manifest {
+		if queryHash(query) != hash {
+			return nil, fmt.Errorf("persisted query manifest: hash %s does not match its document", hash)
+		}
+	}
+	return manifest, nil
+}
+
+// OperationNames returns the names of the operations in manifest, e.g. to
+// label metrics with names clients can't make up.
+func OperationNames(manifest map[string]string) []string {
+	var names []string
+	for _, query := range manifest {
+		doc, err := parser.ParseQuery(&ast.Source{Input: query})
+		if err != nil {
+			continue
+		}
+		for _, op := range doc.Operations {
+			if op.Name != "" {
+				names = append(names, op.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+func (p PersistedQueryAllowlist) ExtensionName() string {
+	return persistedQueryExtension
+}
+
+func (p PersistedQueryAllowlist) Validate(schema graphql.ExecutableSchema) error {
+	if p.Strict && len(p.Manifest) == 0 {
+		return errors.New("PersistedQueryAllowlist strict mode requires a non-empty manifest")
+	}
+	return nil
+}
+
+func (p PersistedQueryAllowlist) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	hash := persistedQueryHash(rawParams)
+
+	if rawParams.Query == "" && hash != "" {
+		if query, ok := p.Manifest[hash]; ok {
+			rawParams.Query = query
+			return nil
+		}
+	}
+
+	if !p.Strict {
+		return nil
+	}
+
+	if rawParams.Query != "" {
+		hash = queryHash(rawParams.Query)
+	}
+	if _, ok := p.Manifest[hash]; !ok {
+		err := gqlerror.Errorf("operation is not in the persisted query allowlist")
+		errcode.Set(err, errPersistedQueryNotAllowed)
+		return err
+	}
+	return nil
+}
+
+func persistedQueryHash(rawParams *graphql.RawParams) string {
+	ext, ok := rawParams.Extensions["persistedQuery"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	hash, _ := ext["sha256Hash"].(string)
+	return hash
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
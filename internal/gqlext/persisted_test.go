@@ -0,0 +1,81 @@
+package gqlext_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const postsQuery = `{ posts { id title } }`
+
+func hashOf(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+func persistedParams(hash, query string) *graphql.RawParams {
+	return &graphql.RawParams{
+		Query: query,
+		Extensions: map[string]any{
+			"persistedQuery": map[string]any{"version": float64(1), "sha256Hash": hash},
+		},
+	}
+}
+
+func TestPersistedQueryAllowlist_ResolvesHashFromManifest(t *testing.T) {
+	ext := gqlext.PersistedQueryAllowlist{Manifest: map[string]string{hashOf(postsQuery): postsQuery}, Strict: true}
+	params := persistedParams(hashOf(postsQuery), "")
+
+	require.Nil(t, ext.MutateOperationParameters(context.Background(), params))
+	assert.Equal(t, postsQuery, params.Query)
+}
+
+func TestPersistedQueryAllowlist_StrictRejectsAdHocQuery(t *testing.T) {
+	ext := gqlext.PersistedQueryAllowlist{Manifest: map[string]string{hashOf(postsQuery): postsQuery}, Strict: true}
+
+	err := ext.MutateOperationParameters(context.Background(), &graphql.RawParams{Query: `{ posts { id } }`})
+	require.NotNil(t, err)
+	assert.Equal(t, "PERSISTED_QUERY_NOT_ALLOWED", err.Extensions["code"])
+
+	err = ext.MutateOperationParameters(context.Background(), persistedParams(hashOf("{ unknown }"), ""))
+	require.NotNil(t, err)
+}
+
+func TestPersistedQueryAllowlist_StrictAcceptsRegisteredDocument(t *testing.T) {
+	ext := gqlext.PersistedQueryAllowlist{Manifest: map[string]string{hashOf(postsQuery): postsQuery}, Strict: true}
+
+	assert.Nil(t, ext.MutateOperationParameters(context.Background(), &graphql.RawParams{Query: postsQuery}))
+}
+
+func TestPersistedQueryAllowlist_NonStrictPassesUnknownQueries(t *testing.T) {
+	ext := gqlext.PersistedQueryAllowlist{Manifest: map[string]string{}}
+	params := persistedParams(hashOf("{ posts { id } }"), "")
+
+	assert.Nil(t, ext.MutateOperationParameters(context.Background(), params))
+	assert.Empty(t, params.Query)
+}
+
+func TestLoadPersistedQueryManifest_RejectsMismatchedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"deadbeef": "{ posts { id } }"}`), 0o644))
+
+	_, err := gqlext.LoadPersistedQueryManifest(path)
+	assert.Error(t, err)
+}
+
+func TestOperationNames(t *testing.T) {
+	names := gqlext.OperationNames(map[string]string{
+		hashOf("a"): `query Posts { posts { id } } query Post { post(id: 1) { id } }`,
+		hashOf("b"): `query Posts { posts { id } }`,
+		hashOf("c"): postsQuery,
+	})
+	assert.Equal(t, []string{"Post", "Posts"}, names)
+}
@@ -0,0 +1,85 @@
+package gqlext
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/middleware"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/ratelimit"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.uber.org/zap"
+)
+
+const (
+	errRateLimited     = "RATE_LIMITED"
+	rateLimitExtension = "RateLimit"
+)
+
+// RateLimit applies per-operation token buckets to mutations and
+// subscription creation, keyed both by the caller's IP and by the author the
+// operation acts on. Limiter failures are logged and let the request through.
+type RateLimit struct {
+	Limiter ratelimit.Limiter
+	Rules   map[string]ratelimit.Rule
+}
+
+var _ interface {
+	graphql.FieldInterceptor
+	graphql.HandlerExtension
+} = RateLimit{}
+
+func (r RateLimit) ExtensionName() string {
+	return rateLimitExtension
+}
+
+func (r RateLimit) Validate(schema graphql.ExecutableSchema) error {
+	if r.Limiter == nil {
+		return errors.New("RateLimit limiter can not be nil")
+	}
+	return nil
+}
+
+func (r RateLimit) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || (fc.Object != "Mutation" && fc.Object != "Subscription") {
+		return next(ctx)
+	}
+	rule, ok := r.Rules[fc.Field.Name]
+	if !ok {
+		return next(ctx)
+	}
+
+	var keys []string
+	if ip := middleware.ClientIPFromContext(ctx); ip != "" {
+		keys = append(keys, fc.Field.Name+":ip:"+ip)
+	}
+	if author, ok := authorFromArgs(fc); ok {
+		keys = append(keys, fc.Field.Name+":author:"+author.String())
+	}
+
+	if len(keys) == 0 {
+		return next(ctx)
+	}
+	// Both buckets are checked in one call, so a request denied for its
+	// author does not spend the token of its IP.
+	res, err := r.Limiter.Allow(ctx, rule, keys...)
+	if err != nil {
+		log.FromContext(ctx).Named(log.PackageGraph).Warn("Rate limiter unavailable, allowing request", zap.Error(err), zap.Strings("keys", keys))
+		return next(ctx)
+	}
+	if !res.Allowed {
+		retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+		return nil, &gqlerror.Error{
+			Message: "rate limit exceeded, retry later",
+			Path:    fc.Path(),
+			Extensions: map[string]any{
+				"code":       errRateLimited,
+				"retryAfter": retryAfter,
+			},
+		}
+	}
+	return next(ctx)
+}
@@ -0,0 +1,75 @@
+package gqlext_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+type recordingLimiter struct {
+	keys   []string
+	denied string
+}
+
+func (l *recordingLimiter) Allow(ctx context.Context, rule ratelimit.Rule, keys ...string) (ratelimit.Result, error) {
+	l.keys = append(l.keys, keys...)
+	for _, key := range keys {
+		if key == l.denied {
+			return ratelimit.Result{Allowed: false, RetryAfter: 1500 * time.Millisecond}, nil
+		}
+	}
+	return ratelimit.Result{Allowed: true}, nil
+}
+
+func fieldContext(object, name string, args map[string]any) context.Context {
+	return graphql.WithFieldContext(context.Background(), &graphql.FieldContext{
+		Object: object,
+		Field:  graphql.CollectedField{Field: &ast.Field{Name: name, Alias: name}},
+		Args:   args,
+	})
+}
+
+func TestRateLimit_RejectsAuthorOverLimit(t *testing.T) {
+	author := uuid.New()
+	limiter := &recordingLimiter{denied: "createComment:author:" + author.String()}
+	ext := gqlext.RateLimit{Limiter: limiter, Rules: map[string]ratelimit.Rule{"createComment": {Rate: 1, Period: time.Second}}}
+
+	ctx := fieldContext("Mutation", "createComment", map[string]any{
+		"commentInput": model.NewComment{AuthorID: author, PostID: 1, Content: "hi"},
+	})
+	res, err := ext.InterceptField(ctx, func(ctx context.Context) (any, error) {
+		t.Fatal("resolver must not run when rate limited")
+		return nil, nil
+	})
+
+	assert.Nil(t, res)
+	var gqlErr *gqlerror.Error
+	require.ErrorAs(t, err, &gqlErr)
+	assert.Equal(t, "RATE_LIMITED", gqlErr.Extensions["code"])
+	assert.Equal(t, 2, gqlErr.Extensions["retryAfter"])
+}
+
+func TestRateLimit_IgnoresQueriesAndUnconfiguredFields(t *testing.T) {
+	limiter := &recordingLimiter{}
+	ext := gqlext.RateLimit{Limiter: limiter, Rules: map[string]ratelimit.Rule{"createComment": {Rate: 1, Period: time.Second}}}
+
+	for _, ctx := range []context.Context{
+		fieldContext("Query", "posts", nil),
+		fieldContext("Mutation", "createPost", nil),
+	} {
+		res, err := ext.InterceptField(ctx, func(ctx context.Context) (any, error) { return "ok", nil })
+		require.NoError(t, err)
+		assert.Equal(t, "ok", res)
+	}
+	assert.Empty(t, limiter.keys)
+}
@@ -0,0 +1,94 @@
+package gqlext
+
+import (
+	"context"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errSubscriptionEnded  = "SUBSCRIPTION_ENDED"
+	subscriptionExtension = "SubscriptionErrors"
+)
+
+type subscriptionEndKey struct{}
+
+type subscriptionEnd struct {
+	mu       sync.Mutex
+	path     ast.Path
+	err      func() error
+	reported bool
+}
+
+// SubscriptionErrors lets a subscription resolver end its stream with an
+// error: after the stream's channel is closed, the error registered with
+// WatchSubscription is sent to the client as a last event before complete.
+type SubscriptionErrors struct{}
+
+var _ interface {
+	graphql.OperationInterceptor
+	graphql.ResponseInterceptor
+	graphql.HandlerExtension
+} = SubscriptionErrors{}
+
+func (SubscriptionErrors) ExtensionName() string {
+	return subscriptionExtension
+}
+
+func (SubscriptionErrors) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (SubscriptionErrors) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	opCtx := graphql.GetOperationContext(ctx)
+	if opCtx.Operation == nil || opCtx.Operation.Operation != ast.Subscription {
+		return next(ctx)
+	}
+	return next(context.WithValue(ctx, subscriptionEndKey{}, &subscriptionEnd{}))
+}
+
+func (SubscriptionErrors) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp != nil {
+		return resp
+	}
+	end, ok := ctx.Value(subscriptionEndKey{}).(*subscriptionEnd)
+	if !ok {
+		return nil
+	}
+
+	end.mu.Lock()
+	defer end.mu.Unlock()
+	if end.err == nil || end.reported {
+		return nil
+	}
+	end.reported = true
+	err := end.err()
+	if err == nil {
+		return nil
+	}
+	return &graphql.Response{Errors: gqlerror.List{{
+		Message:    err.Error(),
+		Path:       end.path,
+		Extensions: map[string]any{"code": errSubscriptionEnded},
+	}}}
+}
+
+// WatchSubscription registers err, called once the stream returned by the
+// resolver has been closed, to report why it ended. It has no effect
+// without the SubscriptionErrors extension.
+func WatchSubscription(ctx context.Context, err func() error) {
+	end, ok := ctx.Value(subscriptionEndKey{}).(*subscriptionEnd)
+	if !ok {
+		return
+	}
+	end.mu.Lock()
+	defer end.mu.Unlock()
+	end.err = err
+	if fc := graphql.GetFieldContext(ctx); fc != nil {
+		end.path = fc.Path()
+	}
+}
@@ -0,0 +1,55 @@
+package gqlext_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/gqlext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func subscriptionResponses(t *testing.T, op ast.Operation, streamErr error) (context.Context, graphql.ResponseHandler) {
+	ext := gqlext.SubscriptionErrors{}
+	ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		Operation: &ast.OperationDefinition{Operation: op},
+	})
+	var innerCtx context.Context
+	handler := ext.InterceptOperation(ctx, func(ctx context.Context) graphql.ResponseHandler {
+		innerCtx = ctx
+		fieldCtx := graphql.WithFieldContext(ctx, &graphql.FieldContext{
+			Object: "Subscription",
+			Field:  graphql.CollectedField{Field: &ast.Field{Name: "commentAdded", Alias: "commentAdded"}},
+		})
+		gqlext.WatchSubscription(fieldCtx, func() error { return streamErr })
+		return func(ctx context.Context) *graphql.Response { return nil }
+	})
+	require.NotNil(t, handler)
+	return innerCtx, func(ctx context.Context) *graphql.Response {
+		return ext.InterceptResponse(ctx, handler)
+	}
+}
+
+func TestSubscriptionErrors_ReportsErrorOnceAfterStreamEnds(t *testing.T) {
+	ctx, responses := subscriptionResponses(t, ast.Subscription, errors.New("too slow"))
+
+	resp := responses(ctx)
+	require.NotNil(t, resp)
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "too slow", resp.Errors[0].Message)
+	assert.Equal(t, "SUBSCRIPTION_ENDED", resp.Errors[0].Extensions["code"])
+	assert.Equal(t, ast.Path{ast.PathName("commentAdded")}, resp.Errors[0].Path)
+
+	assert.Nil(t, responses(ctx), "the stream must complete after the error")
+}
+
+func TestSubscriptionErrors_CompletesWithoutError(t *testing.T) {
+	ctx, responses := subscriptionResponses(t, ast.Subscription, nil)
+	assert.Nil(t, responses(ctx))
+
+	ctx, responses = subscriptionResponses(t, ast.Query, errors.New("ignored"))
+	assert.Nil(t, responses(ctx))
+}
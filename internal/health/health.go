@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const checkTimeout = 2 * time.Second
+
+type Check func(ctx context.Context) error
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo fills BuildInfo from the VCS stamps the go tool embeds in
+// the binary.
+func ReadBuildInfo(version string) BuildInfo {
+	info := BuildInfo{Version: version}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		}
+	}
+	return info
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Health struct {
+	build       BuildInfo
+	storageType string
+	started     time.Time
+	checks      []namedCheck
+	mu          sync.RWMutex
+}
+
+func New(build BuildInfo, storageType string) *Health {
+	return &Health{
+		build:       build,
+		storageType: storageType,
+		started:     time.Now(),
+	}
+}
+
+// AddReadinessCheck registers a dependency that must be healthy for /readyz
+// to report ready.
+func (h *Health) AddReadinessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func (h *Health) Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// Readiness runs every registered check and answers 503 if any of them fails.
+func (h *Health) Readiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		checks := h.checks
+		h.mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		status := http.StatusOK
+		results := make(map[string]string, len(checks))
+		for _, c := range checks {
+			if err := c.check(ctx); err != nil {
+				status = http.StatusServiceUnavailable
+				results[c.name] = err.Error()
+				continue
+			}
+			results[c.name] = "ok"
+		}
+
+		body := map[string]any{"status": "ok", "checks": results}
+		if status != http.StatusOK {
+			body["status"] = "unavailable"
+		}
+		writeJSON(w, status, body)
+	}
+}
+
+// Status describes the running build.
+func (h *Health) Status() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"build":          h.build,
+			"storage":        h.storageType,
+			"started_at":     h.started.UTC().Format(time.RFC3339),
+			"uptime_seconds": int64(time.Since(h.started).Seconds()),
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,56 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, handler http.HandlerFunc) (int, map[string]any) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec.Code, body
+}
+
+func TestReadiness_AllChecksPass(t *testing.T) {
+	h := health.New(health.BuildInfo{Version: "test"}, "memory")
+	h.AddReadinessCheck("storage", func(ctx context.Context) error { return nil })
+
+	code, body := get(t, h.Readiness())
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+	assert.Equal(t, map[string]any{"storage": "ok"}, body["checks"])
+}
+
+func TestReadiness_FailingCheckReturns503(t *testing.T) {
+	h := health.New(health.BuildInfo{Version: "test"}, "db")
+	h.AddReadinessCheck("storage", func(ctx context.Context) error { return nil })
+	h.AddReadinessCheck("migrations", func(ctx context.Context) error { return errors.New("schema version 1, expected 2") })
+
+	code, body := get(t, h.Readiness())
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", body["status"])
+	assert.Equal(t, "schema version 1, expected 2", body["checks"].(map[string]any)["migrations"])
+}
+
+func TestStatus_ReportsBuildAndStorage(t *testing.T) {
+	h := health.New(health.BuildInfo{Version: "1.2.3"}, "db")
+
+	code, body := get(t, h.Status())
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "db", body["storage"])
+	assert.Equal(t, "1.2.3", body["build"].(map[string]any)["version"])
+}
@@ -0,0 +1,53 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ScopePost    = "post"
+	ScopeComment = "comment"
+	MaxKeyLength = 128
+)
+
+type replayKey struct{}
+
+// Scope namespaces keys per author so clients can't collide with each other.
+func Scope(kind string, authorID uuid.UUID) string {
+	return kind + ":" + authorID.String()
+}
+
+func ValidKey(key string) bool {
+	return len(key) > 0 && len(key) <= MaxKeyLength
+}
+
+// Fingerprint hashes the fields of a request, so a key sent again with a
+// different request can be told apart from a retry.
+func Fingerprint(fields ...any) string {
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Track returns a context in which a storage backend can report that a
+// request was answered from a stored idempotency key instead of executed.
+func Track(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey{}, new(atomic.Bool))
+}
+
+func MarkReplayed(ctx context.Context) {
+	if replayed, ok := ctx.Value(replayKey{}).(*atomic.Bool); ok {
+		replayed.Store(true)
+	}
+}
+
+func Replayed(ctx context.Context) bool {
+	replayed, ok := ctx.Value(replayKey{}).(*atomic.Bool)
+	return ok && replayed.Load()
+}
@@ -0,0 +1,38 @@
+package log
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying l.
+func WithLogger(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// With returns a copy of ctx whose logger has the given fields attached.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(fields...))
+}
+
+// FromContext returns the request-scoped logger stored in ctx, falling back
+// to the global logger (or a no-op one before Initialize is called).
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger == nil {
+		return FromContextOr(ctx, zap.NewNop())
+	}
+	return FromContextOr(ctx, logger)
+}
+
+// FromContextOr is like FromContext but falls back to the given logger, so
+// components constructed with their own logger keep using it outside of a
+// request.
+func FromContextOr(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return fallback
+}
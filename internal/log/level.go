@@ -0,0 +1,179 @@
+package log
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger names with their own adjustable level.
+const (
+	PackageStorage = "storage"
+	PackageService = "service"
+	PackageGraph   = "graph"
+)
+
+// levels holds the global level and per-package overrides. Loggers whose name
+// has no override follow the global level.
+type levels struct {
+	global zap.AtomicLevel
+
+	mu       sync.RWMutex
+	packages map[string]zap.AtomicLevel
+}
+
+var atomicLevels = newLevels(zapcore.InfoLevel)
+
+func newLevels(global zapcore.Level) *levels {
+	return &levels{global: zap.NewAtomicLevelAt(global), packages: map[string]zap.AtomicLevel{}}
+}
+
+// forName returns the level for a logger name, matching "storage.db" against
+// an override for "storage".
+func (l *levels) forName(name string) zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for name != "" {
+		if level, ok := l.packages[name]; ok {
+			return level.Level()
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return l.global.Level()
+}
+
+// min returns the most verbose level in use, which is what the core reports
+// as enabled before the logger name is known.
+func (l *levels) min() zapcore.Level {
+	min := l.global.Level()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, level := range l.packages {
+		if lvl := level.Level(); lvl < min {
+			min = lvl
+		}
+	}
+	return min
+}
+
+func (l *levels) set(global string, packages map[string]string) error {
+	globalLevel, err := zapcore.ParseLevel(global)
+	if err != nil {
+		return err
+	}
+	parsed := make(map[string]zapcore.Level, len(packages))
+	for name, value := range packages {
+		level, err := zapcore.ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("package %s: %w", name, err)
+		}
+		parsed[name] = level
+	}
+
+	l.global.SetLevel(globalLevel)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name := range l.packages {
+		if _, ok := parsed[name]; !ok {
+			delete(l.packages, name)
+		}
+	}
+	for name, level := range parsed {
+		if existing, ok := l.packages[name]; ok {
+			existing.SetLevel(level)
+		} else {
+			l.packages[name] = zap.NewAtomicLevelAt(level)
+		}
+	}
+	return nil
+}
+
+func (l *levels) atomic(name string, create bool) (zap.AtomicLevel, bool) {
+	if name == "" {
+		return l.global, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level, ok := l.packages[name]
+	if !ok && create {
+		level = zap.NewAtomicLevelAt(l.global.Level())
+		l.packages[name] = level
+		ok = true
+	}
+	return level, ok
+}
+
+// levelCore drops entries below the level configured for their logger name.
+type levelCore struct {
+	zapcore.Core
+	levels *levels
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= c.levels.min()
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level < c.levels.forName(ent.LoggerName) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// SetLevels replaces the global level and the per-package overrides, e.g.
+// when the configuration is reloaded on SIGHUP.
+func SetLevels(global string, packages map[string]string) error {
+	return atomicLevels.set(global, packages)
+}
+
+// Levels reports the global level and the per-package overrides.
+func Levels() (string, map[string]string) {
+	atomicLevels.mu.RLock()
+	defer atomicLevels.mu.RUnlock()
+	packages := make(map[string]string, len(atomicLevels.packages))
+	for name, level := range atomicLevels.packages {
+		packages[name] = level.String()
+	}
+	return atomicLevels.global.String(), packages
+}
+
+// knownPackage reports whether name is one of the Package constants.
+func knownPackage(name string) bool {
+	switch name {
+	case PackageStorage, PackageService, PackageGraph:
+		return true
+	}
+	return false
+}
+
+// LevelHandler serves zap's AtomicLevel API. GET reports the level and PUT
+// changes it, e.g. `curl -X PUT -d level=debug '/admin/log/level?package=storage'`.
+// Without the package parameter the global level is used; GET for a package
+// without an override reports the global level it follows. Only the Package
+// constants are accepted as packages.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("package")
+		if name != "" && !knownPackage(name) {
+			http.Error(w, fmt.Sprintf("unknown package %q", name), http.StatusBadRequest)
+			return
+		}
+		level, ok := atomicLevels.atomic(name, r.Method == http.MethodPut)
+		if !ok {
+			level = atomicLevels.global
+		}
+		level.ServeHTTP(w, r)
+	})
+}
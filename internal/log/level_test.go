@@ -0,0 +1,105 @@
+package log_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initFileLogger(t *testing.T, cfg log.Config) func() string {
+	t.Helper()
+	cfg.LogFile = filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, log.Initialize(cfg))
+	return func() string {
+		_ = log.Sync()
+		data, err := os.ReadFile(cfg.LogFile)
+		if os.IsNotExist(err) {
+			return ""
+		}
+		require.NoError(t, err)
+		return string(data)
+	}
+}
+
+func TestPackageLevels(t *testing.T) {
+	contents := initFileLogger(t, log.Config{
+		LogLevel:      "info",
+		PackageLevels: map[string]string{log.PackageStorage: "warn", log.PackageService: "debug"},
+	})
+	logger := log.GetLogger()
+
+	logger.Named(log.PackageStorage).Info("storage info")
+	logger.Named(log.PackageStorage).Warn("storage warn")
+	logger.Named(log.PackageService).Debug("service debug")
+	logger.Named(log.PackageGraph).Debug("graph debug")
+	logger.Info("global info")
+
+	out := contents()
+	assert.NotContains(t, out, "storage info")
+	assert.Contains(t, out, "storage warn")
+	assert.Contains(t, out, "service debug")
+	assert.NotContains(t, out, "graph debug")
+	assert.Contains(t, out, "global info")
+}
+
+func TestSetLevelsAtRuntime(t *testing.T) {
+	contents := initFileLogger(t, log.Config{LogLevel: "info"})
+	logger := log.GetLogger().Named(log.PackageStorage)
+
+	logger.Debug("before")
+	require.NoError(t, log.SetLevels("info", map[string]string{log.PackageStorage: "debug"}))
+	logger.Debug("after")
+
+	out := contents()
+	assert.NotContains(t, out, "before")
+	assert.Contains(t, out, "after")
+	assert.Error(t, log.SetLevels("loud", nil))
+}
+
+func TestLevelHandler(t *testing.T) {
+	initFileLogger(t, log.Config{LogLevel: "info"})
+	handler := log.LevelHandler()
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/log/level?package=graph", strings.NewReader(`{"level":"debug"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	global, packages := log.Levels()
+	assert.Equal(t, "info", global)
+	assert.Equal(t, "debug", packages[log.PackageGraph])
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/log/level?package=storage", nil))
+	assert.JSONEq(t, `{"level":"info"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/log/level?package=made-up", strings.NewReader(`{"level":"debug"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	_, packages = log.Levels()
+	assert.NotContains(t, packages, "made-up")
+}
+
+func TestSamplingKeepsWarnings(t *testing.T) {
+	contents := initFileLogger(t, log.Config{
+		LogLevel: "info",
+		Sampling: log.SamplingConfig{Initial: 2, Thereafter: 0},
+	})
+	logger := log.GetLogger()
+
+	for i := 0; i < 5; i++ {
+		logger.Info("hot path")
+		logger.Warn("slow path")
+	}
+
+	out := contents()
+	assert.Equal(t, 2, strings.Count(out, "hot path"))
+	assert.Equal(t, 5, strings.Count(out, "slow path"))
+}
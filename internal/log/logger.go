@@ -2,6 +2,7 @@ package log
 
 import (
 	"os"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -18,6 +19,19 @@ type Config struct {
 	MaxAgeDays int
 	Compress   bool
 	Console    bool
+	// PackageLevels overrides LogLevel for named loggers, e.g. "storage".
+	PackageLevels map[string]string
+	Sampling      SamplingConfig
+	Redaction     RedactionConfig
+}
+
+// SamplingConfig limits repeated Debug and Info messages: per Tick, the first
+// Initial entries with the same message are logged, then every Thereafter-th.
+// Warnings and errors are never sampled. Sampling is off when Initial is 0.
+type SamplingConfig struct {
+	Initial    int
+	Thereafter int
+	Tick       time.Duration
 }
 
 func Initialize(cfg Config) error {
@@ -25,16 +39,24 @@ func Initialize(cfg Config) error {
 		cfg.MaxSizeMB = 100
 	}
 
-	level := zapcore.InfoLevel
-	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
-		level = zapcore.InfoLevel
+	redactor, err := newRedactor(cfg.Redaction)
+	if err != nil {
+		return err
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = zapcore.InfoLevel.String()
 	}
+	if err := atomicLevels.set(cfg.LogLevel, cfg.PackageLevels); err != nil {
+		return err
+	}
+	// Cores accept everything; levelCore applies the adjustable levels.
+	level := zapcore.DebugLevel
 
 	cores := []zapcore.Core{}
 
 	if cfg.LogFile != "" {
 		fileCore := zapcore.NewCore(
-			getJSONEncoder(),
+			newRedactingEncoder(getJSONEncoder(), redactor),
 			getLogWriter(cfg),
 			level,
 		)
@@ -43,7 +65,7 @@ func Initialize(cfg Config) error {
 
 	if cfg.Console || cfg.LogFile == "" {
 		consoleCore := zapcore.NewCore(
-			getConsoleEncoder(),
+			newRedactingEncoder(getConsoleEncoder(), redactor),
 			zapcore.AddSync(os.Stderr),
 			level,
 		)
@@ -51,6 +73,10 @@ func Initialize(cfg Config) error {
 	}
 
 	core := zapcore.NewTee(cores...)
+	if cfg.Sampling.Initial > 0 {
+		core = newSamplingCore(core, cfg.Sampling)
+	}
+	core = &levelCore{Core: core, levels: atomicLevels}
 
 	logger = zap.New(core,
 		zap.AddCaller(),
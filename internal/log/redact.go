@@ -0,0 +1,333 @@
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redaction actions.
+const (
+	RedactNone = "none"
+	RedactMask = "mask"
+	RedactHash = "hash"
+
+	masked = "[REDACTED]"
+)
+
+// RedactionConfig selects what happens to sensitive values before they are
+// encoded. Content applies to content and title fields, IDs to UUID values.
+// Secrets (passwords, tokens, credentials in DSNs) are always masked.
+type RedactionConfig struct {
+	Content string
+	IDs     string
+}
+
+var (
+	dsnPassword = regexp.MustCompile(`(://[^:/@\s]*:)[^@\s]+@`)
+	kvPassword  = regexp.MustCompile(`(?i)(password\s*=\s*)[^\s&]+`)
+)
+
+type redactor struct {
+	content string
+	ids     string
+}
+
+func newRedactor(cfg RedactionConfig) (*redactor, error) {
+	r := &redactor{content: cfg.Content, ids: cfg.IDs}
+	if r.content == "" {
+		r.content = RedactMask
+	}
+	if r.ids == "" {
+		r.ids = RedactHash
+	}
+	for _, action := range []string{r.content, r.ids} {
+		switch action {
+		case RedactNone, RedactMask, RedactHash:
+		default:
+			return nil, fmt.Errorf("unknown redaction action %q", action)
+		}
+	}
+	return r, nil
+}
+
+func normalizeKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+}
+
+func isSecretKey(key string) bool {
+	key = normalizeKey(key)
+	for _, s := range []string{"password", "passwd", "secret", "token", "apikey", "authorization", "dsn"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isContentKey(key string) bool {
+	key = normalizeKey(key)
+	return strings.HasSuffix(key, "content") || strings.HasSuffix(key, "title")
+}
+
+// isCorrelationKey reports keys whose values exist to tie log lines
+// together and must stay readable even when they are UUIDs.
+func isCorrelationKey(key string) bool {
+	switch normalizeKey(key) {
+	case "requestid", "traceid", "spanid":
+		return true
+	}
+	return false
+}
+
+func apply(action, value string) string {
+	switch action {
+	case RedactMask:
+		return masked
+	case RedactHash:
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return value
+}
+
+// redactString returns the value to log for a string under key.
+func (r *redactor) redactString(key, value string) string {
+	switch {
+	case isSecretKey(key):
+		return masked
+	case isContentKey(key):
+		return apply(r.content, value)
+	case isCorrelationKey(key):
+		return value
+	}
+	if len(value) == 36 {
+		if _, err := uuid.Parse(value); err == nil {
+			return apply(r.ids, value)
+		}
+	}
+	return scrubCredentials(value)
+}
+
+func scrubCredentials(value string) string {
+	value = dsnPassword.ReplaceAllString(value, "${1}"+masked+"@")
+	return kvPassword.ReplaceAllString(value, "${1}"+masked)
+}
+
+// redactValue walks a JSON-decoded value, redacting strings by the key they
+// are stored under.
+func (r *redactor) redactValue(key string, v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.redactString(key, v)
+	case map[string]any:
+		for k, elem := range v {
+			if isSecretKey(k) {
+				v[k] = masked
+				continue
+			}
+			v[k] = r.redactValue(k, elem)
+		}
+		return v
+	case []any:
+		for i, elem := range v {
+			v[i] = r.redactValue(key, elem)
+		}
+		return v
+	}
+	return v
+}
+
+func (r *redactor) redactReflected(key string, obj any) (any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return r.redactValue(key, generic), nil
+}
+
+// field returns a copy of f that is safe to encode.
+func (r *redactor) field(f zapcore.Field) (out zapcore.Field) {
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = r.redactString(f.Key, f.String)
+	case zapcore.ByteStringType:
+		return zap.String(f.Key, r.redactString(f.Key, string(f.Interface.([]byte))))
+	case zapcore.StringerType:
+		defer func() {
+			// Leave nil Stringers to zap's own panic handling.
+			if recover() != nil {
+				out = f
+			}
+		}()
+		return zap.String(f.Key, r.redactString(f.Key, f.Interface.(fmt.Stringer).String()))
+	case zapcore.ReflectType:
+		if isSecretKey(f.Key) {
+			return zap.String(f.Key, masked)
+		}
+		redacted, err := r.redactReflected(f.Key, f.Interface)
+		if err != nil {
+			return zap.String(f.Key, masked)
+		}
+		return zap.Any(f.Key, redacted)
+	case zapcore.ObjectMarshalerType:
+		return zap.Object(f.Key, redactedObject{f.Interface.(zapcore.ObjectMarshaler), r})
+	case zapcore.ArrayMarshalerType:
+		return zap.Array(f.Key, redactedArray{f.Interface.(zapcore.ArrayMarshaler), r, f.Key})
+	case zapcore.ErrorType:
+		err, ok := f.Interface.(error)
+		if !ok || err == nil {
+			return f
+		}
+		if msg := err.Error(); scrubCredentials(msg) != msg {
+			return zap.String(f.Key, scrubCredentials(msg))
+		}
+	case zapcore.BinaryType:
+		if isSecretKey(f.Key) {
+			return zap.String(f.Key, masked)
+		}
+	}
+	return f
+}
+
+type redactedObject struct {
+	zapcore.ObjectMarshaler
+	r *redactor
+}
+
+func (o redactedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return o.ObjectMarshaler.MarshalLogObject(&redactingObjectEncoder{enc, o.r})
+}
+
+type redactedArray struct {
+	zapcore.ArrayMarshaler
+	r   *redactor
+	key string
+}
+
+func (a redactedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return a.ArrayMarshaler.MarshalLogArray(&redactingArrayEncoder{enc, a.r, a.key})
+}
+
+// redactingObjectEncoder routes the string-like and nested values that
+// marshalers add through the redactor.
+type redactingObjectEncoder struct {
+	zapcore.ObjectEncoder
+	r *redactor
+}
+
+func (e *redactingObjectEncoder) AddString(key, value string) {
+	e.r.field(zap.String(key, value)).AddTo(e.ObjectEncoder)
+}
+
+func (e *redactingObjectEncoder) AddByteString(key string, value []byte) {
+	e.r.field(zap.ByteString(key, value)).AddTo(e.ObjectEncoder)
+}
+
+func (e *redactingObjectEncoder) AddBinary(key string, value []byte) {
+	e.r.field(zap.Binary(key, value)).AddTo(e.ObjectEncoder)
+}
+
+func (e *redactingObjectEncoder) AddReflected(key string, value any) error {
+	e.r.field(zap.Reflect(key, value)).AddTo(e.ObjectEncoder)
+	return nil
+}
+
+func (e *redactingObjectEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	return e.ObjectEncoder.AddObject(key, redactedObject{marshaler, e.r})
+}
+
+func (e *redactingObjectEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	return e.ObjectEncoder.AddArray(key, redactedArray{marshaler, e.r, key})
+}
+
+type redactingArrayEncoder struct {
+	zapcore.ArrayEncoder
+	r   *redactor
+	key string
+}
+
+func (e *redactingArrayEncoder) AppendString(value string) {
+	e.ArrayEncoder.AppendString(e.r.redactString(e.key, value))
+}
+
+func (e *redactingArrayEncoder) AppendByteString(value []byte) {
+	e.ArrayEncoder.AppendString(e.r.redactString(e.key, string(value)))
+}
+
+func (e *redactingArrayEncoder) AppendReflected(value any) error {
+	redacted, err := e.r.redactReflected(e.key, value)
+	if err != nil {
+		e.ArrayEncoder.AppendString(masked)
+		return nil
+	}
+	return e.ArrayEncoder.AppendReflected(redacted)
+}
+
+func (e *redactingArrayEncoder) AppendObject(marshaler zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(redactedObject{marshaler, e.r})
+}
+
+func (e *redactingArrayEncoder) AppendArray(marshaler zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(redactedArray{marshaler, e.r, e.key})
+}
+
+// redactingEncoder wraps an encoder so that fields added with Logger.With and
+// fields passed per entry are redacted before they are serialized.
+type redactingEncoder struct {
+	zapcore.Encoder
+	r *redactor
+}
+
+func newRedactingEncoder(enc zapcore.Encoder, r *redactor) zapcore.Encoder {
+	return &redactingEncoder{Encoder: enc, r: r}
+}
+
+func (e *redactingEncoder) Clone() zapcore.Encoder {
+	return &redactingEncoder{Encoder: e.Encoder.Clone(), r: e.r}
+}
+
+func (e *redactingEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		redacted[i] = e.r.field(f)
+	}
+	return e.Encoder.EncodeEntry(ent, redacted)
+}
+
+func (e *redactingEncoder) AddString(key, value string) {
+	e.r.field(zap.String(key, value)).AddTo(e.Encoder)
+}
+
+func (e *redactingEncoder) AddByteString(key string, value []byte) {
+	e.r.field(zap.ByteString(key, value)).AddTo(e.Encoder)
+}
+
+func (e *redactingEncoder) AddBinary(key string, value []byte) {
+	e.r.field(zap.Binary(key, value)).AddTo(e.Encoder)
+}
+
+func (e *redactingEncoder) AddReflected(key string, value any) error {
+	e.r.field(zap.Reflect(key, value)).AddTo(e.Encoder)
+	return nil
+}
+
+func (e *redactingEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	return e.Encoder.AddObject(key, redactedObject{marshaler, e.r})
+}
+
+func (e *redactingEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	return e.Encoder.AddArray(key, redactedArray{marshaler, e.r, key})
+}
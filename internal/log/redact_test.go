@@ -0,0 +1,67 @@
+package log_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type credentials struct {
+	user, password string
+}
+
+func (c credentials) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("user", c.user)
+	enc.AddString("password", c.password)
+	return nil
+}
+
+func TestRedactionKeepsSensitiveValuesOutOfFile(t *testing.T) {
+	contents := initFileLogger(t, log.Config{LogLevel: "debug"})
+	author := uuid.New()
+	const (
+		content = "my very private comment"
+		title   = "secret plans"
+		pass    = "hunter2"
+	)
+
+	requestID := uuid.NewString()
+	logger := log.GetLogger().With(zap.String("principal", author.String()), zap.String("request_id", requestID))
+	logger.Info("Creating new comment", zap.Any("newComment", &model.NewComment{AuthorID: author, PostID: 1, Content: content}))
+	logger.Info("Creating new post", zap.String("title", title), zap.Stringer("author", author))
+	logger.Info("Connecting", zap.String("url", "postgres://app:"+pass+"@db:5432/posts"), zap.Object("creds", credentials{"app", pass}))
+	logger.Error("Failed to connect", zap.Error(errors.New("dial postgres://app:"+pass+"@db failed")))
+
+	out := contents()
+	require.NotEmpty(t, out)
+	for _, sensitive := range []string{content, title, pass, author.String()} {
+		assert.NotContains(t, out, sensitive)
+	}
+	assert.Contains(t, out, `"postID":1`)
+	assert.Contains(t, out, requestID)
+	assert.Contains(t, out, "sha256:")
+	assert.Contains(t, out, "[REDACTED]")
+}
+
+func TestRedactionPolicy(t *testing.T) {
+	contents := initFileLogger(t, log.Config{
+		LogLevel:  "info",
+		Redaction: log.RedactionConfig{Content: log.RedactHash, IDs: log.RedactNone},
+	})
+	author := uuid.New()
+
+	log.GetLogger().Info("Creating new post", zap.String("content", "hello"), zap.String("authorID", author.String()))
+
+	out := contents()
+	assert.NotContains(t, out, "hello")
+	assert.Contains(t, out, author.String())
+
+	assert.Error(t, log.Initialize(log.Config{Redaction: log.RedactionConfig{Content: "shred"}}))
+}
@@ -0,0 +1,34 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// samplingCore samples entries below Warn and passes the rest through.
+type samplingCore struct {
+	zapcore.Core
+	sampled zapcore.Core
+}
+
+func newSamplingCore(core zapcore.Core, cfg SamplingConfig) zapcore.Core {
+	if cfg.Tick <= 0 {
+		cfg.Tick = time.Second
+	}
+	return &samplingCore{
+		Core:    core,
+		sampled: zapcore.NewSamplerWithOptions(core, cfg.Tick, cfg.Initial, cfg.Thereafter),
+	}
+}
+
+func (c *samplingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &samplingCore{Core: c.Core.With(fields), sampled: c.sampled.With(fields)}
+}
+
+func (c *samplingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level >= zapcore.WarnLevel {
+		return c.Core.Check(ent, ce)
+	}
+	return c.sampled.Check(ent, ce)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns     *prometheus.Desc
+	idleConns         *prometheus.Desc
+	totalConns        *prometheus.Desc
+	maxConns          *prometheus.Desc
+	acquireCount      *prometheus.Desc
+	acquireDuration   *prometheus.Desc
+	emptyAcquireCount *prometheus.Desc
+	canceledAcquire   *prometheus.Desc
+}
+
+// NewPoolCollector exposes pgxpool.Stat on every scrape.
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool:              pool,
+		acquiredConns:     desc("acquired_conns", "Connections currently in use."),
+		idleConns:         desc("idle_conns", "Connections currently idle."),
+		totalConns:        desc("total_conns", "Total connections in the pool."),
+		maxConns:          desc("max_conns", "Maximum size of the pool."),
+		acquireCount:      desc("acquire_total", "Successful connection acquisitions."),
+		acquireDuration:   desc("acquire_duration_seconds_total", "Total time spent waiting for a connection."),
+		emptyAcquireCount: desc("empty_acquire_total", "Acquisitions that had to wait for a connection."),
+		canceledAcquire:   desc("canceled_acquire_total", "Acquisitions cancelled by their context."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquireCount
+	ch <- c.canceledAcquire
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquire, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
+
+// SubscriptionStats is implemented by subscription.SubscriptionService.
+type SubscriptionStats interface {
+	SubscriberCounts() map[int64]int
+	Dropped() uint64
+	DroppedComments() uint64
+}
+
+type subscriptionCollector struct {
+	stats           SubscriptionStats
+	subscribers     *prometheus.Desc
+	dropped         *prometheus.Desc
+	droppedComments *prometheus.Desc
+}
+
+// NewSubscriptionCollector exposes active commentAdded subscribers per post
+// and what was dropped for subscribers that did not keep up.
+func NewSubscriptionCollector(stats SubscriptionStats) prometheus.Collector {
+	return &subscriptionCollector{
+		stats: stats,
+		subscribers: prometheus.NewDesc(prometheus.BuildFQName(namespace, "subscriptions", "active"),
+			"Active comment subscriptions per post.", []string{"post_id"}, nil),
+		dropped: prometheus.NewDesc(prometheus.BuildFQName(namespace, "subscriptions", "dropped_total"),
+			"Subscribers disconnected because their buffer was full.", nil, nil),
+		droppedComments: prometheus.NewDesc(prometheus.BuildFQName(namespace, "subscriptions", "dropped_comments_total"),
+			"Buffered comments discarded to make room for newer ones.", nil, nil),
+	}
+}
+
+func (c *subscriptionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.subscribers
+	ch <- c.dropped
+	ch <- c.droppedComments
+}
+
+func (c *subscriptionCollector) Collect(ch chan<- prometheus.Metric) {
+	for postID, count := range c.stats.SubscriberCounts() {
+		ch <- prometheus.MustNewConstMetric(c.subscribers, prometheus.GaugeValue, float64(count), strconv.FormatInt(postID, 10))
+	}
+	ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(c.stats.Dropped()))
+	ch <- prometheus.MustNewConstMetric(c.droppedComments, prometheus.CounterValue, float64(c.stats.DroppedComments()))
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// GraphQL records operation and resolver latency. Subscriptions are left out
+// of the operation histogram because their responses are individual events.
+// Operation names come from clients, so only the known ones are used as
+// labels; the others are recorded as "other".
+type GraphQL struct {
+	m     *Metrics
+	names map[string]bool
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = GraphQL{}
+
+// GraphQL returns the extension labelling operations by the given names.
+func (m *Metrics) GraphQL(operationNames ...string) GraphQL {
+	names := make(map[string]bool, len(operationNames))
+	for _, name := range operationNames {
+		names[name] = true
+	}
+	return GraphQL{m: m, names: names}
+}
+
+func (g GraphQL) ExtensionName() string {
+	return "Metrics"
+}
+
+func (g GraphQL) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (g GraphQL) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if !graphql.HasOperationContext(ctx) {
+		return resp
+	}
+
+	opCtx := graphql.GetOperationContext(ctx)
+	if opCtx.Operation == nil || opCtx.Operation.Operation == ast.Subscription {
+		return resp
+	}
+
+	name := opCtx.OperationName
+	switch {
+	case name == "":
+		name = "anonymous"
+	case !g.names[name]:
+		name = "other"
+	}
+	result := "success"
+	if resp != nil && len(resp.Errors) > 0 {
+		result = "error"
+	}
+	g.m.operationDuration.
+		WithLabelValues(name, string(opCtx.Operation.Operation), result).
+		Observe(time.Since(opCtx.Stats.OperationStart).Seconds())
+	return resp
+}
+
+func (g GraphQL) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	start := time.Now()
+	res, err := next(ctx)
+	g.m.resolverDuration.
+		WithLabelValues(fc.Object, fc.Field.Name, status(err)).
+		Observe(time.Since(start).Seconds())
+	return res, err
+}
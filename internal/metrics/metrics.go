@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ozon_posts"
+
+type Metrics struct {
+	registry          *prometheus.Registry
+	operationDuration *prometheus.HistogramVec
+	resolverDuration  *prometheus.HistogramVec
+	storageDuration   *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m := &Metrics{
+		registry: registry,
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "graphql",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of GraphQL queries and mutations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "type", "status"}),
+		resolverDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "graphql",
+			Name:      "resolver_duration_seconds",
+			Help:      "Duration of GraphQL field resolvers.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"object", "field", "status"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "call_duration_seconds",
+			Help:      "Duration of storage calls by backend and method.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"backend", "method", "status"}),
+	}
+	registry.MustRegister(m.operationDuration, m.resolverDuration, m.storageDuration)
+	return m
+}
+
+// Register adds extra collectors, such as the pool and subscription
+// collectors, to the registry served by Handler.
+func (m *Metrics) Register(cs ...prometheus.Collector) {
+	m.registry.MustRegister(cs...)
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
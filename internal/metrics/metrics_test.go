@@ -0,0 +1,85 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/metrics"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+	gomock "go.uber.org/mock/gomock"
+)
+
+type fakeSubscriptions struct{}
+
+func (fakeSubscriptions) SubscriberCounts() map[int64]int { return map[int64]int{7: 3} }
+
+func (fakeSubscriptions) Dropped() uint64 { return 2 }
+
+func (fakeSubscriptions) DroppedComments() uint64 { return 5 }
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestInstrumentStorage_RecordsStatusPerMethod(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockStorage(ctrl)
+	m := metrics.New()
+	s := m.InstrumentStorage(mockStorage, "memory")
+
+	mockStorage.EXPECT().GetPost(gomock.Any(), int64(1)).Return(&model.Post{ID: 1}, nil)
+	mockStorage.EXPECT().GetPost(gomock.Any(), int64(2)).Return(nil, errors.New("boom"))
+
+	post, err := s.GetPost(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), post.ID)
+	_, err = s.GetPost(context.Background(), 2)
+	assert.Error(t, err)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `ozon_posts_storage_call_duration_seconds_count{backend="memory",method="GetPost",status="success"} 1`)
+	assert.Contains(t, body, `ozon_posts_storage_call_duration_seconds_count{backend="memory",method="GetPost",status="error"} 1`)
+}
+
+func TestSubscriptionCollector(t *testing.T) {
+	m := metrics.New()
+	m.Register(metrics.NewSubscriptionCollector(fakeSubscriptions{}))
+
+	body := scrape(t, m)
+	assert.True(t, strings.Contains(body, `ozon_posts_subscriptions_active{post_id="7"} 3`))
+	assert.True(t, strings.Contains(body, `ozon_posts_subscriptions_dropped_total 2`))
+	assert.True(t, strings.Contains(body, `ozon_posts_subscriptions_dropped_comments_total 5`))
+}
+
+func TestGraphQL_LabelsOnlyKnownOperationNames(t *testing.T) {
+	m := metrics.New()
+	ext := m.GraphQL("Posts")
+	for _, name := range []string{"Posts", "Random123", ""} {
+		ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+			OperationName: name,
+			Operation:     &ast.OperationDefinition{Operation: ast.Query},
+			Stats:         graphql.Stats{OperationStart: time.Now()},
+		})
+		ext.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response { return &graphql.Response{} })
+	}
+
+	body := scrape(t, m)
+	for _, label := range []string{"Posts", "other", "anonymous"} {
+		assert.Contains(t, body, `operation="`+label+`"`)
+	}
+	assert.NotContains(t, body, "Random123")
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+)
+
+type instrumentedStorage struct {
+	next    storage.Storage
+	backend string
+	m       *Metrics
+}
+
+// InstrumentStorage wraps s so every call is observed in the storage
+// latency histogram under the given backend label. The wrapper only
+// implements storage.Storage: optional interfaces of s, such as
+// storage.Admin or storage.Outbox, have to be asserted on s itself.
+func (m *Metrics) InstrumentStorage(s storage.Storage, backend string) storage.Storage {
+	return &instrumentedStorage{next: s, backend: backend, m: m}
+}
+
+func (s *instrumentedStorage) observe(method string, start time.Time, err error) {
+	s.m.storageDuration.WithLabelValues(s.backend, method, status(err)).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStorage) CreatePost(ctx context.Context, newPost *model.NewPost) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.CreatePost(ctx, newPost)
+	s.observe("CreatePost", start, err)
+	return post, err
+}
+
+func (s *instrumentedStorage) CreateComment(ctx context.Context, newComment *model.NewComment) (*model.Comment, error) {
+	start := time.Now()
+	comment, err := s.next.CreateComment(ctx, newComment)
+	s.observe("CreateComment", start, err)
+	return comment, err
+}
+
+func (s *instrumentedStorage) AllowComments(ctx context.Context, authorID string, postID int64, allowed bool) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.AllowComments(ctx, authorID, postID, allowed)
+	s.observe("AllowComments", start, err)
+	return post, err
+}
+
+func (s *instrumentedStorage) UpdatePost(ctx context.Context, authorID string, postID int64, title, content *string) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.UpdatePost(ctx, authorID, postID, title, content)
+	s.observe("UpdatePost", start, err)
+	return post, err
+}
+
+func (s *instrumentedStorage) EditComment(ctx context.Context, authorID string, commentID int64, content string) (*model.Comment, error) {
+	start := time.Now()
+	comment, err := s.next.EditComment(ctx, authorID, commentID, content)
+	s.observe("EditComment", start, err)
+	return comment, err
+}
+
+func (s *instrumentedStorage) DeleteComment(ctx context.Context, authorID string, commentID int64) (*model.Comment, error) {
+	start := time.Now()
+	comment, err := s.next.DeleteComment(ctx, authorID, commentID)
+	s.observe("DeleteComment", start, err)
+	return comment, err
+}
+
+func (s *instrumentedStorage) GetPosts(ctx context.Context) ([]*model.Post, error) {
+	start := time.Now()
+	posts, err := s.next.GetPosts(ctx)
+	s.observe("GetPosts", start, err)
+	return posts, err
+}
+
+func (s *instrumentedStorage) GetPost(ctx context.Context, id int64) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.GetPost(ctx, id)
+	s.observe("GetPost", start, err)
+	return post, err
+}
+
+func (s *instrumentedStorage) GetCommentsForPost(ctx context.Context, postID int64, offset int64, limit int64) ([]*model.Comment, error) {
+	start := time.Now()
+	comments, err := s.next.GetCommentsForPost(ctx, postID, offset, limit)
+	s.observe("GetCommentsForPost", start, err)
+	return comments, err
+}
+
+func (s *instrumentedStorage) GetCommentDepth(ctx context.Context, commentID int64) (int, error) {
+	start := time.Now()
+	depth, err := s.next.GetCommentDepth(ctx, commentID)
+	s.observe("GetCommentDepth", start, err)
+	return depth, err
+}
+
+func (s *instrumentedStorage) GetRepliesByParentID(ctx context.Context, parentID int64, offset, limit int64) ([]*model.Comment, error) {
+	start := time.Now()
+	replies, err := s.next.GetRepliesByParentID(ctx, parentID, offset, limit)
+	s.observe("GetRepliesByParentID", start, err)
+	return replies, err
+}
+
+func (s *instrumentedStorage) GetCommentsAfter(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+	start := time.Now()
+	comments, err := s.next.GetCommentsAfter(ctx, postID, afterID, limit)
+	s.observe("GetCommentsAfter", start, err)
+	return comments, err
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
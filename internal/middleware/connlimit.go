@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ConnectionLimit caps the long-lived connections, websockets and event
+// streams, that one client IP keeps open on this instance. Clients over the
+// limit get 429 before the upgrade. Its handlers must run inside ClientIP.
+type ConnectionLimit struct {
+	maxPerIP int
+	mu       sync.Mutex
+	conns    map[string]int
+}
+
+// NewConnectionLimit returns a limit of maxPerIP connections per client IP;
+// 0 disables it.
+func NewConnectionLimit(maxPerIP int) *ConnectionLimit {
+	return &ConnectionLimit{maxPerIP: maxPerIP, conns: map[string]int{}}
+}
+
+// Middleware counts every request to next as a connection, for endpoints
+// that only serve streams.
+func (l *ConnectionLimit) Middleware(next http.Handler) http.Handler {
+	return l.handler(next, func(*http.Request) bool { return true })
+}
+
+// Streams only counts websocket upgrades and event-stream requests; other
+// requests pass through.
+func (l *ConnectionLimit) Streams(next http.Handler) http.Handler {
+	return l.handler(next, isStream)
+}
+
+func (l *ConnectionLimit) handler(next http.Handler, counted func(*http.Request) bool) http.Handler {
+	if l.maxPerIP <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !counted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := ClientIPFromContext(r.Context())
+		if !l.acquire(ip) {
+			http.Error(w, "too many open connections", http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *ConnectionLimit) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] >= l.maxPerIP {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *ConnectionLimit) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] == 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// isStream reports whether r opens a websocket or an event stream; both keep
+// the handler running for as long as the connection is open.
+func isStream(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
@@ -0,0 +1,105 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionLimit_CapsOpenStreamsPerIP(t *testing.T) {
+	release := make(chan struct{})
+	opened := make(chan struct{}, 1)
+	handler := middleware.ClientIP(false)(middleware.NewConnectionLimit(1).Streams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("hold") {
+			opened <- struct{}{}
+			<-release
+		}
+	})))
+
+	stream := func(remoteAddr, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Accept", "text/event-stream")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stream("10.0.0.1:1000", "/events/posts/1?hold")
+	}()
+	select {
+	case <-opened:
+	case <-time.After(time.Second):
+		t.Fatal("first stream was not opened")
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, stream("10.0.0.1:1001", "/events/posts/1").Code)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.RemoteAddr = "10.0.0.1:1002"
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "plain requests are not counted")
+
+	assert.Equal(t, http.StatusOK, stream("10.0.0.2:1000", "/events/posts/1").Code, "other IPs have their own limit")
+
+	close(release)
+	<-done
+	require.Equal(t, http.StatusOK, stream("10.0.0.1:1003", "/events/posts/1").Code, "a closed stream frees its slot")
+}
+
+func TestConnectionLimit_MiddlewareCountsEveryRequest(t *testing.T) {
+	limit := middleware.NewConnectionLimit(1)
+	release := make(chan struct{})
+	opened := make(chan struct{})
+	events := middleware.ClientIP(false)(limit.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(opened)
+		<-release
+	})))
+	query := middleware.ClientIP(false)(limit.Streams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		events.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events/posts/1", nil))
+	}()
+	<-opened
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	req.Header.Set("Upgrade", "websocket")
+	query.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "both endpoints share the limit")
+
+	close(release)
+	<-done
+}
+
+func TestCheckOrigin(t *testing.T) {
+	request := func(origin string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://api.example/query", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return req
+	}
+
+	sameHost := middleware.CheckOrigin(nil)
+	assert.True(t, sameHost(request("")), "non-browser clients send no origin")
+	assert.True(t, sameHost(request("https://api.example")))
+	assert.False(t, sameHost(request("https://evil.example")))
+
+	listed := middleware.CheckOrigin([]string{"https://app.example/"})
+	assert.True(t, listed(request("https://APP.example")))
+	assert.False(t, listed(request("https://api.example")))
+
+	assert.True(t, middleware.CheckOrigin([]string{"*"})(request("https://evil.example")))
+}
@@ -0,0 +1,44 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveRequestID(t *testing.T, header string) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	var seen string
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	if header != "" {
+		req.Header.Set(middleware.RequestIDHeader, header)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return seen, rec
+}
+
+func TestRequestID_PropagatesIncomingID(t *testing.T) {
+	seen, rec := serveRequestID(t, "req-42")
+
+	assert.Equal(t, "req-42", seen)
+	assert.Equal(t, "req-42", rec.Header().Get(middleware.RequestIDHeader))
+}
+
+func TestRequestID_AssignsIDWhenMissingOrInvalid(t *testing.T) {
+	for _, header := range []string{"", "has space", strings.Repeat("a", 129)} {
+		seen, rec := serveRequestID(t, header)
+
+		assert.NotEmpty(t, seen)
+		assert.NotEqual(t, header, seen)
+		assert.Equal(t, seen, rec.Header().Get(middleware.RequestIDHeader))
+	}
+}
@@ -86,6 +86,36 @@ func (mr *MockStorageMockRecorder) CreatePost(ctx, newPost any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePost", reflect.TypeOf((*MockStorage)(nil).CreatePost), ctx, newPost)
 }
 
+// DeleteComment mocks base method.
+func (m *MockStorage) DeleteComment(ctx context.Context, authorID string, commentID int64) (*model.Comment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteComment", ctx, authorID, commentID)
+	ret0, _ := ret[0].(*model.Comment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteComment indicates an expected call of DeleteComment.
+func (mr *MockStorageMockRecorder) DeleteComment(ctx, authorID, commentID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteComment", reflect.TypeOf((*MockStorage)(nil).DeleteComment), ctx, authorID, commentID)
+}
+
+// EditComment mocks base method.
+func (m *MockStorage) EditComment(ctx context.Context, authorID string, commentID int64, content string) (*model.Comment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EditComment", ctx, authorID, commentID, content)
+	ret0, _ := ret[0].(*model.Comment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EditComment indicates an expected call of EditComment.
+func (mr *MockStorageMockRecorder) EditComment(ctx, authorID, commentID, content any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EditComment", reflect.TypeOf((*MockStorage)(nil).EditComment), ctx, authorID, commentID, content)
+}
+
 // GetCommentDepth mocks base method.
 func (m *MockStorage) GetCommentDepth(ctx context.Context, commentID int64) (int, error) {
 	m.ctrl.T.Helper()
@@ -101,6 +131,21 @@ func (mr *MockStorageMockRecorder) GetCommentDepth(ctx, commentID any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCommentDepth", reflect.TypeOf((*MockStorage)(nil).GetCommentDepth), ctx, commentID)
 }
 
+// GetCommentsAfter mocks base method.
+func (m *MockStorage) GetCommentsAfter(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCommentsAfter", ctx, postID, afterID, limit)
+	ret0, _ := ret[0].([]*model.Comment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCommentsAfter indicates an expected call of GetCommentsAfter.
+func (mr *MockStorageMockRecorder) GetCommentsAfter(ctx, postID, afterID, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCommentsAfter", reflect.TypeOf((*MockStorage)(nil).GetCommentsAfter), ctx, postID, afterID, limit)
+}
+
 // GetCommentsForPost mocks base method.
 func (m *MockStorage) GetCommentsForPost(ctx context.Context, postID, offset, limit int64) ([]*model.Comment, error) {
 	m.ctrl.T.Helper()
@@ -160,3 +205,71 @@ func (mr *MockStorageMockRecorder) GetRepliesByParentID(ctx, parentID, offset, l
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRepliesByParentID", reflect.TypeOf((*MockStorage)(nil).GetRepliesByParentID), ctx, parentID, offset, limit)
 }
+
+// Ping mocks base method.
+func (m *MockStorage) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockStorageMockRecorder) Ping(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStorage)(nil).Ping), ctx)
+}
+
+// UpdatePost mocks base method.
+func (m *MockStorage) UpdatePost(ctx context.Context, authorID string, postID int64, title, content *string) (*model.Post, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePost", ctx, authorID, postID, title, content)
+	ret0, _ := ret[0].(*model.Post)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdatePost indicates an expected call of UpdatePost.
+func (mr *MockStorageMockRecorder) UpdatePost(ctx, authorID, postID, title, content any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePost", reflect.TypeOf((*MockStorage)(nil).UpdatePost), ctx, authorID, postID, title, content)
+}
+
+// MockIdempotencyKeys is a mock of IdempotencyKeys interface.
+type MockIdempotencyKeys struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdempotencyKeysMockRecorder
+	isgomock struct{}
+}
+
+// MockIdempotencyKeysMockRecorder is the mock recorder for MockIdempotencyKeys.
+type MockIdempotencyKeysMockRecorder struct {
+	mock *MockIdempotencyKeys
+}
+
+// NewMockIdempotencyKeys creates a new mock instance.
+func NewMockIdempotencyKeys(ctrl *gomock.Controller) *MockIdempotencyKeys {
+	mock := &MockIdempotencyKeys{ctrl: ctrl}
+	mock.recorder = &MockIdempotencyKeysMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdempotencyKeys) EXPECT() *MockIdempotencyKeysMockRecorder {
+	return m.recorder
+}
+
+// PurgeIdempotencyKeys mocks base method.
+func (m *MockIdempotencyKeys) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeIdempotencyKeys", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeIdempotencyKeys indicates an expected call of PurgeIdempotencyKeys.
+func (mr *MockIdempotencyKeysMockRecorder) PurgeIdempotencyKeys(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeIdempotencyKeys", reflect.TypeOf((*MockIdempotencyKeys)(nil).PurgeIdempotencyKeys), ctx)
+}
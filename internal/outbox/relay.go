@@ -0,0 +1,183 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"go.uber.org/zap"
+)
+
+// Sink receives the events of the outbox. Delivery is at least once: when a
+// sink fails, the event is retried later on every sink, so the ones that
+// already got it get it again.
+type Sink interface {
+	Deliver(ctx context.Context, event *storage.OutboxEvent) error
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(ctx context.Context, event *storage.OutboxEvent) error
+
+func (f SinkFunc) Deliver(ctx context.Context, event *storage.OutboxEvent) error {
+	return f(ctx, event)
+}
+
+// Options configures a Relay. Zero values are replaced by the defaults.
+type Options struct {
+	// PollInterval is how often the outbox is checked for events this
+	// process was not told about: those stored by other instances, left
+	// by a previous run, or whose delivery failed.
+	PollInterval time.Duration
+	BatchSize    int
+	// Retention is how long delivered events are kept before they are
+	// pruned.
+	Retention time.Duration
+	// MaxAttempts is how many times an event is tried before it is parked
+	// and the events after it are delivered.
+	MaxAttempts int
+	// MaxBackoff caps the wait before a failed event is retried, which
+	// starts at PollInterval and doubles with every attempt.
+	MaxBackoff time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.Retention <= 0 {
+		o.Retention = 24 * time.Hour
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 10
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Minute
+	}
+	return o
+}
+
+// Relay delivers the events of an outbox to its sinks, in the order they
+// were stored. An event that fails holds back the ones after it until it is
+// delivered or, after MaxAttempts, parked.
+type Relay struct {
+	store storage.Outbox
+	sinks []Sink
+	opts  Options
+}
+
+func NewRelay(store storage.Outbox, opts Options, sinks ...Sink) *Relay {
+	return &Relay{store: store, sinks: sinks, opts: opts.withDefaults()}
+}
+
+// Run delivers events as they are stored and prunes delivered ones until
+// ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	poll := time.NewTicker(r.opts.PollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(min(r.opts.Retention, time.Hour))
+	defer prune.Stop()
+	// While a failed event waits for its retry, neither new events nor
+	// the poll interval wake the relay.
+	var retry <-chan time.Time
+	for {
+		if retry == nil {
+			if wait := r.drain(ctx); wait > 0 {
+				retry = time.After(wait)
+			}
+		}
+		ready, polled := r.store.OutboxReady(), poll.C
+		if retry != nil {
+			ready, polled = nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ready:
+		case <-polled:
+		case <-retry:
+			retry = nil
+		case <-prune.C:
+			r.prune(ctx)
+		}
+	}
+}
+
+// drain processes batches until the outbox is empty, a delivery fails or
+// another instance is processing it. After a failed delivery it returns how
+// long to wait before the event is retried.
+func (r *Relay) drain(ctx context.Context) time.Duration {
+	logger := log.FromContext(ctx).Named(log.PackageService)
+	for ctx.Err() == nil {
+		var failed *storage.OutboxEvent
+		n, err := r.store.ProcessOutbox(ctx, r.opts.BatchSize, func(ctx context.Context, events []*storage.OutboxEvent) (int, error) {
+			n, err := r.deliver(ctx, events)
+			if err != nil {
+				failed = events[n]
+			}
+			return n, err
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to process outbox", zap.Error(err))
+			}
+			return 0
+		}
+		if failed != nil && failed.Attempts+1 < r.opts.MaxAttempts {
+			return r.backoff(failed.Attempts + 1)
+		}
+		// A parked event ends its batch early, but the events after it
+		// can be delivered right away.
+		if n < r.opts.BatchSize && failed == nil {
+			return 0
+		}
+	}
+	return 0
+}
+
+// backoff returns the wait before the next delivery of an event that failed
+// attempts times.
+func (r *Relay) backoff(attempts int) time.Duration {
+	wait := r.opts.PollInterval
+	for i := 1; i < attempts && wait < r.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, r.opts.MaxBackoff)
+}
+
+func (r *Relay) deliver(ctx context.Context, events []*storage.OutboxEvent) (int, error) {
+	logger := log.FromContext(ctx).Named(log.PackageService)
+	for i, event := range events {
+		for _, sink := range r.sinks {
+			err := sink.Deliver(ctx, event)
+			if err == nil {
+				continue
+			}
+			fields := []zap.Field{zap.Error(err), zap.Int64("event_id", event.ID),
+				zap.String("kind", string(event.Kind)), zap.Int("attempts", event.Attempts+1)}
+			if event.Attempts+1 >= r.opts.MaxAttempts {
+				logger.Error("Parking event after failed deliveries", fields...)
+				return i, fmt.Errorf("%w: %w", errs.ErrEventParked, err)
+			}
+			logger.Warn("Failed to deliver event", fields...)
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+func (r *Relay) prune(ctx context.Context) {
+	n, err := r.store.PruneOutbox(ctx, time.Now().Add(-r.opts.Retention))
+	if err != nil {
+		log.FromContext(ctx).Named(log.PackageService).Warn("Failed to prune outbox", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		log.FromContext(ctx).Named(log.PackageService).Debug("Outbox pruned", zap.Int64("events", n))
+	}
+}
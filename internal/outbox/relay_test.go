@@ -0,0 +1,142 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/outbox"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	inmemory "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a sink keeping the IDs of the events it got.
+type recorder struct {
+	mu     sync.Mutex
+	ids    []int64
+	events []*storage.OutboxEvent
+	// fail makes the first delivery of the event with this ID fail, or
+	// every delivery of it if always is set.
+	fail   int64
+	always bool
+	failed bool
+}
+
+func (r *recorder) Deliver(ctx context.Context, event *storage.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if event.ID == r.fail && (r.always || !r.failed) {
+		r.failed = true
+		return errors.New("sink unavailable")
+	}
+	r.ids = append(r.ids, event.ID)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recorder) received() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.ids...)
+}
+
+func run(t *testing.T, relay *outbox.Relay) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRelay_RetriesFailedEventsInOrder(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	comment, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "first"})
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, ParentID: &comment.ID, Content: "reply"})
+	require.NoError(t, err)
+
+	first := &recorder{fail: -1}
+	second := &recorder{fail: 1}
+	run(t, outbox.NewRelay(s, outbox.Options{PollInterval: 10 * time.Millisecond}, first, second))
+
+	assert.Eventually(t, func() bool { return len(second.received()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int64{0, 1, 2}, second.received(), "later events wait for the failed one")
+	assert.Equal(t, []int64{0, 1, 1, 2}, first.received(), "sinks that got a failed event get it again")
+
+	reply := second.events[2]
+	assert.Equal(t, storage.EventCommentAdded, reply.Kind)
+	assert.Equal(t, []int64{comment.ID}, reply.Ancestors)
+	assert.Equal(t, 1, second.events[1].Attempts)
+}
+
+func TestRelay_ParksEventThatKeepsFailing(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	for _, content := range []string{"first", "second"} {
+		_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: content})
+		require.NoError(t, err)
+	}
+
+	sink := &recorder{fail: 1, always: true}
+	run(t, outbox.NewRelay(s, outbox.Options{PollInterval: time.Millisecond, MaxAttempts: 3}, sink))
+
+	assert.Eventually(t, func() bool { return len(sink.received()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int64{0, 2}, sink.received(), "events after the parked one are delivered")
+
+	pruned, err := s.PruneOutbox(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pruned, "the parked event is kept")
+	_, err = s.ProcessOutbox(ctx, 10, func(ctx context.Context, events []*storage.OutboxEvent) (int, error) {
+		assert.Empty(t, events, "the parked event is not delivered again")
+		return len(events), nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRelay_PublishesToSubscribers(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	svc, err := subscription.NewSubscriptionService(ctx, subscription.NewMemoryBroker(), subscription.Options{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+	// A long poll interval shows that stored events wake the relay.
+	run(t, outbox.NewRelay(s, outbox.Options{PollInterval: time.Hour}, svc))
+
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: uuid.New(), Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub, err := svc.Subscribe(subCtx, post.ID)
+	require.NoError(t, err)
+
+	comment, err := s.CreateComment(ctx, &model.NewComment{AuthorID: uuid.New(), PostID: post.ID, Content: "hello"})
+	require.NoError(t, err)
+
+	select {
+	case event := <-sub.C():
+		assert.Equal(t, subscription.EventCommentAdded, event.Kind)
+		assert.Equal(t, comment.ID, event.CommentID)
+		assert.Equal(t, "hello", event.Comment.Content)
+	case <-time.After(time.Second):
+		t.Fatal("comment was not published")
+	}
+}
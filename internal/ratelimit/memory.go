@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+// MemoryLimiter keeps buckets in process memory. It is only accurate for a
+// single server instance.
+type MemoryLimiter struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, rule Rule, keys ...string) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	buckets := make([]*bucket, len(keys))
+	var retryAfter time.Duration
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(rule.Rate), last: now, period: rule.Period}
+			l.buckets[key] = b
+		}
+
+		elapsed := now.Sub(b.last)
+		b.tokens = min(float64(rule.Rate), b.tokens+elapsed.Seconds()*float64(rule.Rate)/rule.Period.Seconds())
+		b.last = now
+		buckets[i] = b
+
+		if b.tokens < 1 {
+			retryAfter = max(retryAfter, time.Duration((1-b.tokens)*float64(rule.refillInterval())))
+		}
+	}
+	if retryAfter > 0 {
+		return Result{Allowed: false, RetryAfter: retryAfter}, nil
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return Result{Allowed: true}, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.period {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is a token bucket that holds up to Rate tokens and refills Rate
+// tokens every Period.
+type Rule struct {
+	Rate   int
+	Period time.Duration
+}
+
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket of every key, or from none of them:
+// a request denied by one bucket does not spend the others. RetryAfter is
+// the longest wait among the denying buckets.
+type Limiter interface {
+	Allow(ctx context.Context, rule Rule, keys ...string) (Result, error)
+}
+
+// ParseRule parses rules written as "<rate>/<period>", e.g. "30/1m".
+func ParseRule(s string) (Rule, error) {
+	rate, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("invalid rate limit rule %q: expected <rate>/<period>", s)
+	}
+	n, err := strconv.Atoi(rate)
+	if err != nil || n <= 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit rule %q: rate must be a positive integer", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit rule %q: period must be a positive duration", s)
+	}
+	return Rule{Rate: n, Period: d}, nil
+}
+
+// ParseRules parses a set of rules keyed by operation name.
+func ParseRules(rules map[string]string) (map[string]Rule, error) {
+	parsed := make(map[string]Rule, len(rules))
+	for op, s := range rules {
+		rule, err := ParseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		parsed[op] = rule
+	}
+	return parsed, nil
+}
+
+func (r Rule) refillInterval() time.Duration {
+	return r.Period / time.Duration(r.Rate)
+}
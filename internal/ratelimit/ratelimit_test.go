@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("30/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Rule{Rate: 30, Period: time.Minute}, rule)
+
+	for _, invalid := range []string{"30", "0/1m", "x/1m", "30/0s", "30/soon"} {
+		_, err := ParseRule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func testLimiter(t *testing.T, limiter Limiter, clock *fakeClock) {
+	ctx := context.Background()
+	rule := Rule{Rate: 2, Period: time.Second}
+
+	for i := 0; i < 2; i++ {
+		res, err := limiter.Allow(ctx, rule, "createComment:ip:127.0.0.1")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+
+	res, err := limiter.Allow(ctx, rule, "createComment:ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	res, err = limiter.Allow(ctx, rule, "createComment:ip:10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "buckets must be independent per key")
+
+	clock.advance(500 * time.Millisecond)
+	res, err = limiter.Allow(ctx, rule, "createComment:ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "a token must be refilled after the retry-after delay")
+}
+
+func testLimiterSpendsAllOrNone(t *testing.T, limiter Limiter) {
+	ctx := context.Background()
+	rule := Rule{Rate: 1, Period: time.Second}
+	ip, author := "createComment:ip:192.0.2.1", "createComment:author:a"
+
+	res, err := limiter.Allow(ctx, rule, author)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+
+	res, err = limiter.Allow(ctx, rule, ip, author)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	res, err = limiter.Allow(ctx, rule, ip)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "a request denied for its author must not spend its IP's token")
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	limiter := NewMemoryLimiter()
+	limiter.now = clock.now
+
+	testLimiter(t, limiter, clock)
+	testLimiterSpendsAllOrNone(t, limiter)
+}
+
+func TestRedisLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	limiter := NewRedisLimiter(client, "ratelimit:")
+	limiter.now = clock.now
+
+	testLimiter(t, limiter, clock)
+	testLimiterSpendsAllOrNone(t, limiter)
+}
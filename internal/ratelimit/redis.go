@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills the buckets of all keys and takes a token from
+// each atomically, or from none if any is empty. A bucket expires once it
+// would be full again, so idle keys do not accumulate.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local tokens = {}
+local retry = 0
+for i, key in ipairs(KEYS) do
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local t = tonumber(state[1]) or rate
+	local ts = tonumber(state[2]) or now
+	t = math.min(rate, t + math.max(0, now - ts) * rate / period)
+	if t < 1 then
+		retry = math.max(retry, math.ceil((1 - t) * period / rate))
+	end
+	tokens[i] = t
+end
+
+local allowed = 0
+if retry == 0 then
+	allowed = 1
+end
+for i, key in ipairs(KEYS) do
+	local t = tokens[i]
+	if allowed == 1 then
+		t = t - 1
+	end
+	redis.call('HSET', key, 'tokens', tostring(t), 'ts', now)
+	redis.call('PEXPIRE', key, period)
+end
+return {allowed, retry}
+`)
+
+// RedisLimiter shares buckets between all server instances.
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+	now    func() time.Time
+}
+
+func NewRedisLimiter(client *redis.Client, prefix string) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, rule Rule, keys ...string) (Result, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = l.prefix + key
+	}
+	res, err := tokenBucketScript.Run(ctx, l.client, prefixed,
+		rule.Rate, rule.Period.Milliseconds(), l.now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+	if len(res) != 2 {
+		return Result{}, fmt.Errorf("unexpected rate limit script reply: %v", res)
+	}
+	return Result{
+		Allowed:    res[0] == 1,
+		RetryAfter: time.Duration(res[1]) * time.Millisecond,
+	}, nil
+}
@@ -3,12 +3,26 @@ package commentservice
 import (
 	"context"
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/tracing"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/iamstep4ik/TestTaskOzonBank/internal/service/comment_service")
+
+const (
+	DefaultRepliesLimit = int64(10)
+	MaxRepliesLimit     = int64(100)
+	MaxCommentLength    = 2000
+)
+
 type CommentService struct {
 	storage storage.Storage
 	log     *zap.Logger
@@ -19,68 +33,141 @@ func NewCommentService(storage storage.Storage, logger *zap.Logger) *CommentServ
 }
 
 func (s *CommentService) CreateComment(ctx context.Context, newComment *model.NewComment) (*model.Comment, error) {
-	s.log.Info("Creating new comment", zap.Any("newComment", newComment))
+	ctx, span := tracer.Start(ctx, "CommentService.CreateComment")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Info("Creating new comment", zap.Any("newComment", newComment))
+	if newComment.IdempotencyKey != nil && !idempotency.ValidKey(*newComment.IdempotencyKey) {
+		logger.Warn("Invalid idempotency key", zap.String("authorID", newComment.AuthorID.String()))
+		tracing.RecordError(span, errs.ErrInvalidIdempotencyKey)
+		return nil, errs.ErrInvalidIdempotencyKey
+	}
 	comment, err := s.storage.CreateComment(ctx, newComment)
 	if err != nil {
-		s.log.Error("Failed to create comment", zap.Error(err))
+		tracing.RecordError(span, err)
+		logger.Error("Failed to create comment", zap.Error(err))
+		return nil, err
+	}
+	logger.Info("Comment created successfully", zap.Any("comment", comment))
+	return comment, nil
+
+}
+
+func (s *CommentService) EditComment(ctx context.Context, authorID string, commentID int64, content string) (*model.Comment, error) {
+	ctx, span := tracer.Start(ctx, "CommentService.EditComment")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Info("Editing comment", zap.Int64("comment_id", commentID), zap.String("authorID", authorID))
+	if n := utf8.RuneCountInString(content); n == 0 || n > MaxCommentLength {
+		tracing.RecordError(span, errs.ErrCommentContent)
+		return nil, errs.ErrCommentContent
+	}
+	comment, err := s.storage.EditComment(ctx, authorID, commentID, content)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logger.Error("Failed to edit comment", zap.Error(err), zap.Int64("comment_id", commentID))
 		return nil, err
 	}
-	s.log.Info("Comment created successfully", zap.Any("comment", comment))
 	return comment, nil
+}
+
+// DeleteComment removes the comment and its replies and returns it.
+func (s *CommentService) DeleteComment(ctx context.Context, authorID string, commentID int64) (*model.Comment, error) {
+	ctx, span := tracer.Start(ctx, "CommentService.DeleteComment")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
 
+	logger.Info("Deleting comment", zap.Int64("comment_id", commentID), zap.String("authorID", authorID))
+	comment, err := s.storage.DeleteComment(ctx, authorID, commentID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logger.Error("Failed to delete comment", zap.Error(err), zap.Int64("comment_id", commentID))
+		return nil, err
+	}
+	return comment, nil
 }
 
 func (s *CommentService) GetReplies(ctx context.Context, commentID int64, offset, limit *int64) ([]*model.Comment, error) {
+	ctx, span := tracer.Start(ctx, "CommentService.GetReplies")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
 
 	off := int64(0)
 	if offset != nil {
 		off = *offset
 	}
 
-	lim := int64(10)
+	lim := DefaultRepliesLimit
 	if limit != nil {
 		lim = *limit
 	}
 
-	if lim > 100 {
-		return nil, fmt.Errorf("maximum limit is 100")
+	if lim > MaxRepliesLimit {
+		return nil, fmt.Errorf("maximum limit is %d", MaxRepliesLimit)
 	}
 	if off < 0 {
 		return nil, fmt.Errorf("offset cannot be negative")
 	}
 
-	s.log.Debug("Fetching comment replies",
+	logger.Debug("Fetching comment replies",
 		zap.Int64("comment_id", commentID),
 		zap.Int64("offset", off),
 		zap.Int64("limit", lim))
 
 	replies, err := s.storage.GetRepliesByParentID(ctx, commentID, off, lim)
 	if err != nil {
-		s.log.Error("Failed to get comment replies",
+		tracing.RecordError(span, err)
+		logger.Error("Failed to get comment replies",
 			zap.Error(err),
 			zap.Int64("comment_id", commentID))
 		return nil, fmt.Errorf("failed to get replies: %w", err)
 	}
 
-	s.log.Info("Successfully fetched comment replies",
+	logger.Debug("Successfully fetched comment replies",
 		zap.Int64("comment_id", commentID),
 		zap.Int("reply_count", len(replies)))
 	return replies, nil
 }
 
+// GetCommentsAfter returns up to limit comments on postID newer than
+// afterID, oldest first. Resumed subscriptions replay missed comments with it.
+func (s *CommentService) GetCommentsAfter(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+	ctx, span := tracer.Start(ctx, "CommentService.GetCommentsAfter")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	comments, err := s.storage.GetCommentsAfter(ctx, postID, afterID, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logger.Error("Failed to get comments after ID",
+			zap.Error(err),
+			zap.Int64("post_id", postID),
+			zap.Int64("after_id", afterID))
+		return nil, fmt.Errorf("failed to get comments: %w", err)
+	}
+	return comments, nil
+}
+
 func (s *CommentService) GetCommentDepth(ctx context.Context, commentID int64) (int, error) {
-	s.log.Debug("Calculating comment depth",
+	ctx, span := tracer.Start(ctx, "CommentService.GetCommentDepth")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Calculating comment depth",
 		zap.Int64("comment_id", commentID))
 
 	depth, err := s.storage.GetCommentDepth(ctx, commentID)
 	if err != nil {
-		s.log.Error("Failed to calculate comment depth",
+		tracing.RecordError(span, err)
+		logger.Error("Failed to calculate comment depth",
 			zap.Error(err),
 			zap.Int64("comment_id", commentID))
 		return 0, fmt.Errorf("failed to calculate depth: %w", err)
 	}
 
-	s.log.Info("Comment depth calculated",
+	logger.Debug("Comment depth calculated",
 		zap.Int64("comment_id", commentID),
 		zap.Int("depth", depth))
 	return depth, nil
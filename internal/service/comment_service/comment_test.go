@@ -2,6 +2,7 @@ package commentservice_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/google/uuid"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/mocks"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
 	gomock "go.uber.org/mock/gomock"
 	"go.uber.org/zap"
 )
@@ -75,3 +77,11 @@ func TestGetCommentDepth_Success(t *testing.T) {
 }
 
 func ptr(i int64) *int64 { return &i }
+
+func TestEditComment_RejectsEmptyContent(t *testing.T) {
+	service := commentservice.NewCommentService(nil, zap.NewNop())
+	_, err := service.EditComment(context.Background(), uuid.NewString(), 1, "")
+	if !errors.Is(err, errs.ErrCommentContent) {
+		t.Errorf("expected content error, got: %v", err)
+	}
+}
@@ -2,12 +2,20 @@ package postservice
 
 import (
 	"context"
+	"strings"
 
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/tracing"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/iamstep4ik/TestTaskOzonBank/internal/service/post_service")
+
 type PostService struct {
 	storage storage.Storage
 	log     *zap.Logger
@@ -17,54 +25,115 @@ func NewPostService(storage storage.Storage, logger *zap.Logger) *PostService {
 	return &PostService{storage: storage, log: logger}
 }
 
+// validText reports whether a post title or content is not blank.
+func validText(s string) bool {
+	return strings.TrimSpace(s) != ""
+}
+
 func (s *PostService) CreatePost(ctx context.Context, newPost *model.NewPost) (*model.Post, error) {
-	s.log.Debug("Creating new post", zap.String("authorID", newPost.AuthorID.String()), zap.String("title", newPost.Title))
+	ctx, span := tracer.Start(ctx, "PostService.CreatePost")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Creating new post", zap.String("authorID", newPost.AuthorID.String()), zap.String("title", newPost.Title))
+	if newPost.IdempotencyKey != nil && !idempotency.ValidKey(*newPost.IdempotencyKey) {
+		logger.Warn("Invalid idempotency key", zap.String("authorID", newPost.AuthorID.String()))
+		tracing.RecordError(span, errs.ErrInvalidIdempotencyKey)
+		return nil, errs.ErrInvalidIdempotencyKey
+	}
 	post, err := s.storage.CreatePost(ctx, newPost)
 	if err != nil {
-		s.log.Error("Failed to create post", zap.Error(err), zap.String("authorID", newPost.AuthorID.String()), zap.String("title", newPost.Title))
+		tracing.RecordError(span, err)
+		logger.Error("Failed to create post", zap.Error(err), zap.String("authorID", newPost.AuthorID.String()), zap.String("title", newPost.Title))
 		return nil, err
 	}
-	s.log.Debug("Successfully created post", zap.Int64("postID", post.ID), zap.String("authorID", post.AuthorID.String()), zap.String("title", post.Title))
+	logger.Debug("Successfully created post", zap.Int64("postID", post.ID), zap.String("authorID", post.AuthorID.String()), zap.String("title", post.Title))
 	return post, nil
 }
 func (s *PostService) GetPost(ctx context.Context, id int64) (*model.Post, error) {
-	s.log.Debug("Fetching post", zap.Int64("postID", id))
+	ctx, span := tracer.Start(ctx, "PostService.GetPost")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Fetching post", zap.Int64("postID", id))
 	post, err := s.storage.GetPost(ctx, id)
 	if err != nil {
-		s.log.Error("Failed to get post", zap.Int64("postID", id), zap.Error(err))
+		tracing.RecordError(span, err)
+		logger.Error("Failed to get post", zap.Int64("postID", id), zap.Error(err))
 		return nil, err
 	}
-	s.log.Debug("Successfully fetched post", zap.Int64("postID", id), zap.String("authorID", post.AuthorID.String()))
+	logger.Debug("Successfully fetched post", zap.Int64("postID", id), zap.String("authorID", post.AuthorID.String()))
 	return post, nil
 }
 func (s *PostService) GetPosts(ctx context.Context) ([]*model.Post, error) {
-	s.log.Debug("Fetching all posts")
+	ctx, span := tracer.Start(ctx, "PostService.GetPosts")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Fetching all posts")
 	posts, err := s.storage.GetPosts(ctx)
 	if err != nil {
-		s.log.Error("Failed to get posts", zap.Error(err))
+		tracing.RecordError(span, err)
+		logger.Error("Failed to get posts", zap.Error(err))
 		return nil, err
 	}
-	s.log.Debug("Successfully fetched posts", zap.Int("count", len(posts)))
+	logger.Debug("Successfully fetched posts", zap.Int("count", len(posts)))
 	return posts, nil
 }
 func (s *PostService) AllowComments(ctx context.Context, authorID string, postID int64, allowed bool) (*model.Post, error) {
-	s.log.Debug("Allowing comments for post", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Bool("allowed", allowed))
+	ctx, span := tracer.Start(ctx, "PostService.AllowComments")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Allowing comments for post", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Bool("allowed", allowed))
 	post, err := s.storage.AllowComments(ctx, authorID, postID, allowed)
 	if err != nil {
-		s.log.Error("Failed to allow comments", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Error(err))
+		tracing.RecordError(span, err)
+		logger.Error("Failed to allow comments", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Error(err))
+		return nil, err
+	}
+	logger.Debug("Successfully allowed comments for post", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Bool("allowed", allowed))
+	return post, nil
+}
+
+// UpdatePost changes the title, the content or both; at least one must be
+// given, and neither can be blank.
+func (s *PostService) UpdatePost(ctx context.Context, authorID string, postID int64, title, content *string) (*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.UpdatePost")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Updating post", zap.String("authorID", authorID), zap.Int64("postID", postID))
+	if title == nil && content == nil {
+		tracing.RecordError(span, errs.ErrInvalidInput)
+		return nil, errs.ErrInvalidInput
+	}
+	if (title != nil && !validText(*title)) || (content != nil && !validText(*content)) {
+		tracing.RecordError(span, errs.ErrPostContent)
+		return nil, errs.ErrPostContent
+	}
+	post, err := s.storage.UpdatePost(ctx, authorID, postID, title, content)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logger.Error("Failed to update post", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Error(err))
 		return nil, err
 	}
-	s.log.Debug("Successfully allowed comments for post", zap.String("authorID", authorID), zap.Int64("postID", postID), zap.Bool("allowed", allowed))
+	logger.Debug("Successfully updated post", zap.String("authorID", authorID), zap.Int64("postID", postID))
 	return post, nil
 }
 
 func (s *PostService) GetCommentsForPost(ctx context.Context, postID int64, offset int64, limit int64) ([]*model.Comment, error) {
-	s.log.Debug("Fetching comments for post", zap.Int64("postID", postID), zap.Int64("offset", offset), zap.Int64("limit", limit))
+	ctx, span := tracer.Start(ctx, "PostService.GetCommentsForPost")
+	defer span.End()
+	logger := log.FromContextOr(ctx, s.log).Named(log.PackageService)
+
+	logger.Debug("Fetching comments for post", zap.Int64("postID", postID), zap.Int64("offset", offset), zap.Int64("limit", limit))
 	comments, err := s.storage.GetCommentsForPost(ctx, postID, offset, limit)
 	if err != nil {
-		s.log.Error("Failed to get comments for post", zap.Int64("postID", postID), zap.Error(err))
+		tracing.RecordError(span, err)
+		logger.Error("Failed to get comments for post", zap.Int64("postID", postID), zap.Error(err))
 		return nil, err
 	}
-	s.log.Debug("Successfully fetched comments for post", zap.Int64("postID", postID), zap.Int("count", len(comments)))
+	logger.Debug("Successfully fetched comments for post", zap.Int64("postID", postID), zap.Int("count", len(comments)))
 	return comments, nil
 }
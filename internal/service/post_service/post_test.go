@@ -2,12 +2,14 @@ package postservice_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/google/uuid"
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/mocks"
 	postservice "github.com/iamstep4ik/TestTaskOzonBank/internal/service/post_service"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
 	gomock "go.uber.org/mock/gomock"
 	"go.uber.org/zap"
 )
@@ -126,3 +128,36 @@ func TestGetCommentsForPost_Success(t *testing.T) {
 		t.Errorf("GetCommentsForPost failed: got %v, expected %v", comments, expected)
 	}
 }
+
+func TestCreatePost_InvalidIdempotencyKey(t *testing.T) {
+	service := postservice.NewPostService(nil, zap.NewNop())
+	key := ""
+
+	_, err := service.CreatePost(context.Background(), &model.NewPost{AuthorID: uuid.New(), IdempotencyKey: &key})
+	if !errors.Is(err, errs.ErrInvalidIdempotencyKey) {
+		t.Errorf("expected invalid idempotency key error, got: %v", err)
+	}
+}
+
+func TestUpdatePost_RequiresAChange(t *testing.T) {
+	service := postservice.NewPostService(nil, zap.NewNop())
+	_, err := service.UpdatePost(context.Background(), uuid.NewString(), 1, nil, nil)
+	if !errors.Is(err, errs.ErrInvalidInput) {
+		t.Errorf("expected invalid input error, got: %v", err)
+	}
+}
+
+func TestUpdatePost_RejectsBlankText(t *testing.T) {
+	service := postservice.NewPostService(nil, zap.NewNop())
+	blank := " "
+	_, err := service.UpdatePost(context.Background(), uuid.NewString(), 1, &blank, nil)
+	if !errors.Is(err, errs.ErrPostContent) {
+		t.Errorf("expected post content error for a blank title, got: %v", err)
+	}
+
+	empty := ""
+	_, err = service.UpdatePost(context.Background(), uuid.NewString(), 1, nil, &empty)
+	if !errors.Is(err, errs.ErrPostContent) {
+		t.Errorf("expected post content error for empty content, got: %v", err)
+	}
+}
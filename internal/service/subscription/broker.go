@@ -0,0 +1,65 @@
+package subscription
+
+import (
+	"context"
+	"sync"
+)
+
+// Broker carries published events to every instance of the server, so a
+// subscriber sees changes made on any replica.
+type Broker interface {
+	// Publish sends the event to all instances, this one included.
+	Publish(ctx context.Context, event *Event) error
+	// Listen returns once the broker is ready and from then on passes
+	// events published on any instance to handle, until Close.
+	Listen(ctx context.Context, handle func(*Event)) error
+	Close() error
+}
+
+// AllPosts asks a PostWatcher for the events of every post.
+const AllPosts int64 = -1
+
+// PostWatcher is implemented by brokers that only receive the events of the
+// posts they watch, so an instance isn't sent the events nobody on it
+// subscribed to. Presence events reach every instance regardless. Brokers
+// that don't implement it receive every event.
+type PostWatcher interface {
+	// WatchPost returns once the events of postID, or of every post for
+	// AllPosts, are received.
+	WatchPost(ctx context.Context, postID int64) error
+	UnwatchPost(ctx context.Context, postID int64) error
+}
+
+// MemoryBroker delivers events within the process. It is enough for a
+// single instance and for STORAGE_TYPE=memory, where nothing is shared.
+type MemoryBroker struct {
+	handle func(*Event)
+	mu     sync.RWMutex
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, event *Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.handle != nil {
+		b.handle(event)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Listen(ctx context.Context, handle func(*Event)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handle = handle
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handle = nil
+	return nil
+}
@@ -0,0 +1,196 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	notifyChannel = "post_events"
+	// maxNotifyPayload is the largest payload NOTIFY accepts with the
+	// default server build.
+	maxNotifyPayload = 7999
+
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+)
+
+// notification is the NOTIFY payload. Events whose comment or post doesn't
+// fit are sent without it as Ref, and every listener loads it by ID.
+type notification struct {
+	Event *Event `json:"event,omitempty"`
+	Ref   *Event `json:"ref,omitempty"`
+}
+
+// PostgresBroker shares events between instances with LISTEN/NOTIFY on the
+// application database. Events published while a listener is reconnecting
+// are not delivered to it. Listening takes a connection of its own, outside
+// the pool, so each instance opens one more connection than the pool allows.
+type PostgresBroker struct {
+	pool   *pgxpool.Pool
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewPostgresBroker(pool *pgxpool.Pool) *PostgresBroker {
+	return &PostgresBroker{pool: pool, cancel: func() {}}
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, event *Event) error {
+	payload, err := encodeNotification(event)
+	if err != nil {
+		return err
+	}
+	if _, err := b.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, payload); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", notifyChannel, err)
+	}
+	return nil
+}
+
+func encodeNotification(event *Event) (string, error) {
+	payload, err := json.Marshal(notification{Event: event})
+	if err != nil {
+		return "", err
+	}
+	if len(payload) > maxNotifyPayload {
+		ref := *event
+		ref.Comment, ref.Post = nil, nil
+		payload, err = json.Marshal(notification{Ref: &ref})
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(payload), nil
+}
+
+// Listen opens a connection with the pool's settings for LISTEN and keeps
+// it, or a replacement after a connection loss, until Close. A busy pool
+// doesn't hold up listening or reconnecting.
+func (b *PostgresBroker) Listen(ctx context.Context, handle func(*Event)) error {
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	go b.run(runCtx, conn, handle)
+	return nil
+}
+
+func (b *PostgresBroker) connect(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, b.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open listener connection: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		conn.Close(context.WithoutCancel(ctx))
+		return nil, fmt.Errorf("failed to listen on %s: %w", notifyChannel, err)
+	}
+	return conn, nil
+}
+
+func (b *PostgresBroker) run(ctx context.Context, conn *pgx.Conn, handle func(*Event)) {
+	defer close(b.done)
+	logger := log.FromContext(ctx).Named(log.PackageService)
+	delay := minReconnectDelay
+	for {
+		err := b.receive(ctx, conn, handle)
+		conn.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("Lost notification listener connection", zap.Error(err))
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxReconnectDelay)
+			if conn, err = b.connect(ctx); err == nil {
+				break
+			}
+			logger.Warn("Failed to reconnect notification listener", zap.Error(err), zap.Duration("retry_in", delay))
+		}
+		logger.Info("Notification listener reconnected")
+		delay = minReconnectDelay
+	}
+}
+
+func (b *PostgresBroker) receive(ctx context.Context, conn *pgx.Conn, handle func(*Event)) error {
+	logger := log.FromContext(ctx).Named(log.PackageService)
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var msg notification
+		if err := json.Unmarshal([]byte(n.Payload), &msg); err != nil {
+			logger.Warn("Ignoring malformed notification", zap.Error(err))
+			continue
+		}
+		event := msg.Event
+		if event == nil && msg.Ref != nil {
+			if event, err = b.load(ctx, msg.Ref); err != nil {
+				logger.Warn("Failed to load notified event", zap.Error(err),
+					zap.String("kind", string(msg.Ref.Kind)), zap.Int64("post_id", msg.Ref.PostID), zap.Int64("comment_id", msg.Ref.CommentID))
+				continue
+			}
+		}
+		if event != nil {
+			handle(event)
+		}
+	}
+}
+
+// load fills in the comment or post of an event sent by reference. The row
+// may have changed since the event was published.
+func (b *PostgresBroker) load(ctx context.Context, ref *Event) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	event := *ref
+	var err error
+	switch ref.Kind {
+	case EventCommentAdded, EventCommentEdited:
+		event.Comment = &model.Comment{}
+		err = b.pool.QueryRow(ctx, `SELECT comment_id, author_id, post_id, parent_id, content, created_at
+			FROM comments WHERE comment_id = $1`, ref.CommentID).
+			Scan(&event.Comment.ID, &event.Comment.AuthorID, &event.Comment.PostID, &event.Comment.ParentID, &event.Comment.Content, &event.Comment.CreatedAt)
+	default:
+		event.Post = &model.Post{}
+		err = b.pool.QueryRow(ctx, `SELECT post_id, author_id, title, content, allow_comments, created_at
+			FROM posts WHERE post_id = $1`, ref.PostID).
+			Scan(&event.Post.ID, &event.Post.AuthorID, &event.Post.Title, &event.Post.Content, &event.Post.CommentsAllowed, &event.Post.CreatedAt)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%s event refers to a deleted row", ref.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Close stops listening and waits for the listener connection to close.
+func (b *PostgresBroker) Close() error {
+	b.once.Do(func() {
+		b.cancel()
+		if b.done != nil {
+			<-b.done
+		}
+	})
+	return nil
+}
@@ -0,0 +1,103 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeNotification(t *testing.T) {
+	comment := &model.Comment{ID: 5, PostID: 1, AuthorID: uuid.New(), Content: "hi"}
+
+	payload, err := encodeNotification(CommentAdded(comment))
+	require.NoError(t, err)
+	var msg notification
+	require.NoError(t, json.Unmarshal([]byte(payload), &msg))
+	require.NotNil(t, msg.Event)
+	assert.Equal(t, comment.Content, msg.Event.Comment.Content)
+	assert.Nil(t, msg.Ref)
+
+	// 2000 characters of escaped control runes exceed the NOTIFY limit.
+	comment.Content = strings.Repeat("\x01", 2000)
+	payload, err = encodeNotification(CommentEdited(comment))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(payload), maxNotifyPayload)
+	msg = notification{}
+	require.NoError(t, json.Unmarshal([]byte(payload), &msg))
+	assert.Nil(t, msg.Event)
+	assert.Equal(t, &Event{Kind: EventCommentEdited, PostID: 1, CommentID: 5}, msg.Ref)
+}
+
+// testPool connects to the database at TEST_DATABASE_URL with a pool of a
+// single connection. The test is skipped when the variable is not set.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	config, err := pgxpool.ParseConfig(url)
+	require.NoError(t, err)
+	config.MaxConns = 1
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestPostgresBroker_ListensAndReconnects(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	// The listener has a connection of its own, so a busy pool doesn't
+	// keep it from starting.
+	busy, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	listener := NewPostgresBroker(pool)
+	events := make(chan *Event, 16)
+	listenCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, listener.Listen(listenCtx, func(event *Event) { events <- event }))
+	t.Cleanup(func() { listener.Close() })
+	busy.Release()
+
+	publisher := NewPostgresBroker(pool)
+	comment := &model.Comment{ID: 5, PostID: 1, AuthorID: uuid.New(), Content: "hi"}
+	require.NoError(t, publisher.Publish(ctx, CommentAdded(comment)))
+	select {
+	case event := <-events:
+		assert.Equal(t, EventCommentAdded, event.Kind)
+		assert.Equal(t, comment.Content, event.Comment.Content)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the notification")
+	}
+
+	// Terminating the listener's backend stands for a lost connection.
+	var terminated int
+	require.NoError(t, pool.QueryRow(ctx, `SELECT count(pg_terminate_backend(pid)) FROM pg_stat_activity
+		WHERE pid <> pg_backend_pid() AND query = 'LISTEN `+notifyChannel+`'`).Scan(&terminated))
+	require.NotZero(t, terminated)
+
+	// Notifications sent before the listener is back are lost, so publish
+	// until one arrives.
+	require.Eventually(t, func() bool {
+		if err := publisher.Publish(ctx, CommentEdited(comment)); err != nil {
+			return false
+		}
+		select {
+		case event := <-events:
+			return event.Kind == EventCommentEdited
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 15*time.Second, 10*time.Millisecond, "the listener reconnects and listens again")
+}
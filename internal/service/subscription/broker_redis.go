@@ -0,0 +1,207 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	redisChannelPrefix = "events:post:"
+	// RedisPresenceChannel carries the presence events of all posts, which
+	// every instance needs to count viewers.
+	RedisPresenceChannel = "events:presence"
+	redisAllPosts        = redisChannelPrefix + "*"
+)
+
+// RedisChannel returns the Pub/Sub channel events of postID are sent to.
+func RedisChannel(postID int64) string {
+	return redisChannelPrefix + strconv.FormatInt(postID, 10)
+}
+
+// RedisBroker shares events between instances with Redis Pub/Sub, one
+// channel per post. An instance subscribes to the channel of a post while
+// it watches it, and to all of them with a pattern while it watches
+// AllPosts. Like NOTIFY, Pub/Sub does not keep messages: events published
+// while a listener is reconnecting are not delivered to it.
+type RedisBroker struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+
+	// confirmed holds the WatchPost calls waiting for Redis to confirm
+	// their subscription, by channel or pattern.
+	mu        sync.Mutex
+	confirmed map[string][]chan struct{}
+}
+
+var _ PostWatcher = (*RedisBroker)(nil)
+
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{client: client, cancel: func() {}, confirmed: make(map[string][]chan struct{})}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	channel := RedisChannel(event.PostID)
+	if event.Kind == EventViewers || event.Kind == EventTyping {
+		channel = RedisPresenceChannel
+	}
+	if err := b.client.Publish(ctx, channel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Listen subscribes to the presence channel; the channels of posts are
+// subscribed to by WatchPost. Subscriptions are restored by go-redis when
+// its connection is replaced.
+func (b *RedisBroker) Listen(ctx context.Context, handle func(*Event)) error {
+	pubsub := b.client.Subscribe(ctx, RedisPresenceChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", RedisPresenceChannel, err)
+	}
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	b.pubsub = pubsub
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	go b.run(runCtx, pubsub, handle)
+	return nil
+}
+
+// WatchPost subscribes to the channel of postID, or to the channels of all
+// posts for AllPosts, and waits for Redis to confirm it.
+func (b *RedisBroker) WatchPost(ctx context.Context, postID int64) error {
+	if b.pubsub == nil {
+		return ErrClosed
+	}
+	channel, subscribe := RedisChannel(postID), b.pubsub.Subscribe
+	if postID == AllPosts {
+		channel, subscribe = redisAllPosts, b.pubsub.PSubscribe
+	}
+	confirmed := make(chan struct{})
+	b.mu.Lock()
+	b.confirmed[channel] = append(b.confirmed[channel], confirmed)
+	b.mu.Unlock()
+
+	err := subscribe(ctx, channel)
+	if err == nil {
+		select {
+		case <-confirmed:
+			return nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	// go-redis keeps the subscription to restore it after a reconnect, so
+	// it is dropped for the caller, which sees the watch as failed.
+	b.mu.Lock()
+	waiting := b.confirmed[channel]
+	if i := slices.Index(waiting, confirmed); i >= 0 {
+		b.confirmed[channel] = slices.Delete(waiting, i, i+1)
+	}
+	b.mu.Unlock()
+	b.UnwatchPost(context.WithoutCancel(ctx), postID)
+	return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+}
+
+func (b *RedisBroker) UnwatchPost(ctx context.Context, postID int64) error {
+	if b.pubsub == nil {
+		return ErrClosed
+	}
+	if postID == AllPosts {
+		return b.pubsub.PUnsubscribe(ctx, redisAllPosts)
+	}
+	return b.pubsub.Unsubscribe(ctx, RedisChannel(postID))
+}
+
+func (b *RedisBroker) confirm(channel string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, confirmed := range b.confirmed[channel] {
+		close(confirmed)
+	}
+	delete(b.confirmed, channel)
+}
+
+func (b *RedisBroker) run(ctx context.Context, pubsub *redis.PubSub, handle func(*Event)) {
+	defer close(b.done)
+	logger := log.FromContext(ctx).Named(log.PackageService)
+	delay := minReconnectDelay
+	// While the pattern of all posts is subscribed to, an event on a post
+	// channel that is subscribed to as well arrives twice, once for the
+	// channel and once for the pattern; only the latter is handled.
+	allPosts := false
+	for {
+		// Receive reconnects and resubscribes after a failed read.
+		msg, err := pubsub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			allPosts = false
+			logger.Warn("Lost Redis subscription connection", zap.Error(err), zap.Duration("retry_in", delay))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxReconnectDelay)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			switch msg.Kind {
+			case "subscribe", "psubscribe":
+				if delay > minReconnectDelay {
+					logger.Info("Redis subscription restored")
+				}
+				delay = minReconnectDelay
+				allPosts = allPosts || msg.Channel == redisAllPosts
+				b.confirm(msg.Channel)
+			case "punsubscribe":
+				allPosts = allPosts && msg.Channel != redisAllPosts
+			}
+		case *redis.Message:
+			delay = minReconnectDelay
+			if allPosts && msg.Pattern == "" && msg.Channel != RedisPresenceChannel {
+				continue
+			}
+			event := &Event{}
+			if err := json.Unmarshal([]byte(msg.Payload), event); err != nil {
+				logger.Warn("Ignoring malformed event message", zap.Error(err), zap.String("channel", msg.Channel))
+				continue
+			}
+			handle(event)
+		}
+	}
+}
+
+// Close unsubscribes and waits for the listener to stop. The client is
+// left open for its owner to close.
+func (b *RedisBroker) Close() error {
+	b.once.Do(func() {
+		b.cancel()
+		if b.pubsub == nil {
+			return
+		}
+		// Closing the subscription interrupts a blocked Receive.
+		b.pubsub.Close()
+		<-b.done
+	})
+	return nil
+}
@@ -0,0 +1,160 @@
+package subscription_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisService(t *testing.T, mr *miniredis.Miniredis) *subscription.SubscriptionService {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	svc, err := subscription.NewSubscriptionService(context.Background(), subscription.NewRedisBroker(client), subscription.Options{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+	return svc
+}
+
+func TestRedisBroker_DeliversAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedisService(t, mr)
+	b := newRedisService(t, mr)
+
+	sub, _ := subscribe(t, a, 7)
+	other, _ := subscribe(t, a, 8)
+
+	comment := &model.Comment{ID: 1, PostID: 7, AuthorID: uuid.New(), Content: "from b", CreatedAt: time.Now().UTC()}
+	require.NoError(t, b.Publish(context.Background(), subscription.CommentAdded(comment)))
+
+	select {
+	case received := <-sub.C():
+		assert.Equal(t, subscription.EventCommentAdded, received.Kind)
+		assert.Equal(t, comment.ID, received.Comment.ID)
+		assert.Equal(t, comment.Content, received.Comment.Content)
+		assert.True(t, comment.CreatedAt.Equal(received.Comment.CreatedAt))
+	case <-time.After(time.Second):
+		t.Fatal("timeout: comment published on another instance not received")
+	}
+	select {
+	case event := <-other.C():
+		t.Fatalf("comment on post 7 delivered to post 8: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRedisBroker_PublishesToPostChannel(t *testing.T) {
+	mr := miniredis.RunT(t)
+	svc := newRedisService(t, mr)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	pubsub := client.Subscribe(context.Background(), subscription.RedisChannel(3))
+	defer pubsub.Close()
+	_, err := pubsub.Receive(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, svc.Publish(context.Background(), subscription.CommentDeleted(3, 9)))
+
+	msg, err := pubsub.ReceiveMessage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "events:post:3", msg.Channel)
+	assert.JSONEq(t, `{"kind":"comment_deleted","postID":3,"commentID":9}`, msg.Payload)
+}
+
+func TestRedisBroker_SubscribesToWatchedPostsOnly(t *testing.T) {
+	mr := miniredis.RunT(t)
+	svc := newRedisService(t, mr)
+	numSub := func(postID int64) int {
+		channel := subscription.RedisChannel(postID)
+		return mr.PubSubNumSub(channel)[channel]
+	}
+	assert.Zero(t, numSub(7))
+
+	_, stopFirst := subscribe(t, svc, 7)
+	_, stopSecond := subscribe(t, svc, 7)
+	assert.Equal(t, 1, numSub(7), "one Redis subscription per post")
+	assert.Zero(t, numSub(8))
+	assert.Zero(t, mr.PubSubNumPat())
+
+	stopFirst()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, numSub(7), "the post is watched while it has subscribers")
+	stopSecond()
+	assert.Eventually(t, func() bool { return numSub(7) == 0 }, time.Second, 5*time.Millisecond)
+
+	// Subscribers of posts created or of authors need the events of all
+	// posts.
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := svc.SubscribePostsCreated(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, mr.PubSubNumPat())
+	cancel()
+	assert.Eventually(t, func() bool { return mr.PubSubNumPat() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestRedisBroker_DeliversOnceWithPostAndPatternSubscriptions(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedisService(t, mr)
+	b := newRedisService(t, mr)
+
+	sub, _ := subscribe(t, a, 7)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	byAuthor, err := a.SubscribeAuthor(ctx, uuid.New())
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, subscription.CommentAdded(&model.Comment{ID: 1, PostID: 7, AuthorID: uuid.New()})))
+	require.NoError(t, b.Publish(ctx, subscription.CommentAdded(&model.Comment{ID: 2, PostID: 7, AuthorID: uuid.New()})))
+	assert.Equal(t, []int64{1, 2}, receive(t, sub, 2))
+	select {
+	case event := <-sub.C():
+		t.Fatalf("comment %d delivered twice", event.CommentID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case event := <-byAuthor.C():
+		t.Fatalf("comment %d of another author delivered", event.CommentID)
+	default:
+	}
+}
+
+func TestRedisBroker_PresenceReachesInstancesWithoutSubscribers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedisService(t, mr)
+	b := newRedisService(t, mr)
+
+	subscribe(t, a, 5)
+	assert.Eventually(t, func() bool { return b.Viewers(5) == 1 }, 15*time.Second, 10*time.Millisecond,
+		"viewer counts reach instances that don't watch the post")
+}
+
+func TestRedisBroker_ResubscribesAfterConnectionLoss(t *testing.T) {
+	mr := miniredis.RunT(t)
+	svc := newRedisService(t, mr)
+	sub, _ := subscribe(t, svc, 1)
+
+	mr.Close()
+	require.NoError(t, mr.Restart())
+
+	// Messages published before the listener has resubscribed are lost and
+	// publishing fails until the client reconnects, so retry until one arrives.
+	require.Eventually(t, func() bool {
+		if err := svc.Publish(context.Background(), subscription.CommentAdded(&model.Comment{ID: 2, PostID: 1})); err != nil {
+			return false
+		}
+		select {
+		case <-sub.C():
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+}
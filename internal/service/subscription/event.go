@@ -0,0 +1,160 @@
+package subscription
+
+import (
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+)
+
+// EventKind tells what changed.
+type EventKind string
+
+const (
+	EventCommentAdded    EventKind = "comment_added"
+	EventCommentEdited   EventKind = "comment_edited"
+	EventCommentDeleted  EventKind = "comment_deleted"
+	EventCommentsToggled EventKind = "comments_toggled"
+	EventPostUpdated     EventKind = "post_updated"
+	EventPostCreated     EventKind = "post_created"
+	// EventViewers is an instance's count of the commentAdded subscribers
+	// of a post and EventTyping an author typing a comment on it. They only
+	// travel between instances; subscribers get EventPresenceChanged.
+	EventViewers         EventKind = "viewers"
+	EventTyping          EventKind = "typing"
+	EventPresenceChanged EventKind = "presence_changed"
+)
+
+// Event is a change published to subscribers. CommentID is set for comment
+// events, Comment for added and edited comments and Post for post events.
+// Ancestors of an added reply are the IDs of its parent and the parent's
+// ancestors, nearest first. Instance and Viewers are set for viewers events,
+// Author for typing events and Presence for presence events.
+type Event struct {
+	Kind      EventKind       `json:"kind"`
+	PostID    int64           `json:"postID"`
+	CommentID int64           `json:"commentID,omitempty"`
+	Ancestors []int64         `json:"ancestors,omitempty"`
+	Comment   *model.Comment  `json:"comment,omitempty"`
+	Post      *model.Post     `json:"post,omitempty"`
+	Instance  string          `json:"instance,omitempty"`
+	Viewers   int             `json:"viewers,omitempty"`
+	Author    *uuid.UUID      `json:"author,omitempty"`
+	Presence  *model.Presence `json:"presence,omitempty"`
+	// seq orders the presence events of this instance, which are sent
+	// after the presence lock is released and can race each other.
+	seq uint64
+}
+
+// CommentAdded reports a new comment. For a reply, ancestors must hold the
+// path from its parent to the top-level comment, see Event.
+func CommentAdded(comment *model.Comment, ancestors ...int64) *Event {
+	return &Event{Kind: EventCommentAdded, PostID: comment.PostID, CommentID: comment.ID, Ancestors: ancestors, Comment: comment}
+}
+
+func CommentEdited(comment *model.Comment) *Event {
+	return &Event{Kind: EventCommentEdited, PostID: comment.PostID, CommentID: comment.ID, Comment: comment}
+}
+
+// CommentDeleted reports the removal of a comment together with its
+// replies.
+func CommentDeleted(postID, commentID int64) *Event {
+	return &Event{Kind: EventCommentDeleted, PostID: postID, CommentID: commentID}
+}
+
+func CommentsToggled(post *model.Post) *Event {
+	return &Event{Kind: EventCommentsToggled, PostID: post.ID, Post: post}
+}
+
+func PostUpdated(post *model.Post) *Event {
+	return &Event{Kind: EventPostUpdated, PostID: post.ID, Post: post}
+}
+
+func PostCreated(post *model.Post) *Event {
+	return &Event{Kind: EventPostCreated, PostID: post.ID, Post: post}
+}
+
+// FromOutbox returns the event for a domain event of the outbox, or nil if
+// its kind is not published to subscribers.
+func FromOutbox(event *storage.OutboxEvent) *Event {
+	switch {
+	case event.Comment != nil:
+		switch event.Kind {
+		case storage.EventCommentAdded:
+			return CommentAdded(event.Comment, event.Ancestors...)
+		case storage.EventCommentEdited:
+			return CommentEdited(event.Comment)
+		case storage.EventCommentDeleted:
+			return CommentDeleted(event.PostID, event.Comment.ID)
+		}
+	case event.Post != nil:
+		switch event.Kind {
+		case storage.EventPostCreated:
+			return PostCreated(event.Post)
+		case storage.EventPostUpdated:
+			return PostUpdated(event.Post)
+		case storage.EventCommentsToggled:
+			return CommentsToggled(event.Post)
+		}
+	}
+	return nil
+}
+
+type topicKind int
+
+const (
+	// topicComments receives the comments added to a post.
+	topicComments topicKind = iota
+	// topicPost receives every event of a post.
+	topicPost
+	// topicPosts receives every created post.
+	topicPosts
+	// topicReplies receives the replies added anywhere below a comment.
+	topicReplies
+	// topicAuthor receives the comments added by an author.
+	topicAuthor
+	// topicViewers receives the presence changes of a post.
+	topicViewers
+)
+
+// topic is what subscribers are indexed by, so an event only reaches the
+// subscribers of the topics it belongs to. id is the post ID, or the comment
+// ID for topicReplies.
+type topic struct {
+	kind   topicKind
+	id     int64
+	author uuid.UUID
+}
+
+// post returns the post whose events the topic is made of, or AllPosts if
+// they can come from any post.
+func (t topic) post() int64 {
+	switch t.kind {
+	case topicComments, topicPost, topicViewers:
+		return t.id
+	default:
+		return AllPosts
+	}
+}
+
+func (e *Event) topics() []topic {
+	switch e.Kind {
+	case EventCommentAdded:
+		topics := make([]topic, 0, 3+len(e.Ancestors))
+		topics = append(topics, topic{kind: topicComments, id: e.PostID}, topic{kind: topicPost, id: e.PostID})
+		for _, id := range e.Ancestors {
+			topics = append(topics, topic{kind: topicReplies, id: id})
+		}
+		if e.Comment != nil {
+			topics = append(topics, topic{kind: topicAuthor, author: e.Comment.AuthorID})
+		}
+		return topics
+	case EventPostCreated:
+		return []topic{{kind: topicPosts}}
+	case EventViewers, EventTyping:
+		return nil
+	case EventPresenceChanged:
+		return []topic{{kind: topicViewers, id: e.PostID}}
+	default:
+		return []topic{{kind: topicPost, id: e.PostID}}
+	}
+}
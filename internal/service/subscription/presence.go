@@ -0,0 +1,331 @@
+package subscription
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"go.uber.org/zap"
+)
+
+// presence tracks who is on each post. The commentAdded subscribers of this
+// instance are counted from its subscribers; other instances announce their
+// counts through the broker whenever they change and every
+// PresenceInterval, and a count not announced again for three intervals is
+// dropped, as happens when an instance goes away. Typing indicators last
+// TypingTTL unless set again, or until their author adds a comment.
+type presence struct {
+	mu       sync.Mutex
+	instance string
+	remote   map[int64]map[string]remoteViewers
+	typing   map[int64]map[uuid.UUID]time.Time
+	// seq numbers the presence events built, see Event.
+	seq uint64
+	// changed holds the posts whose local count has to be announced.
+	changed  map[int64]struct{}
+	announce chan struct{}
+	stop     chan struct{}
+}
+
+type remoteViewers struct {
+	count int
+	seen  time.Time
+}
+
+func newPresence() *presence {
+	return &presence{
+		instance: uuid.NewString(),
+		remote:   make(map[int64]map[string]remoteViewers),
+		typing:   make(map[int64]map[uuid.UUID]time.Time),
+		changed:  make(map[int64]struct{}),
+		announce: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Viewers returns the number of commentAdded subscribers of postID on all
+// instances.
+func (s *SubscriptionService) Viewers(postID int64) int {
+	s.presence.mu.Lock()
+	defer s.presence.mu.Unlock()
+	return s.viewersLocked(postID, time.Now())
+}
+
+// SubscribeViewers starts a subscription to the presence of postID: its
+// viewers and the authors typing a comment. The current presence is sent
+// first, then every change.
+func (s *SubscriptionService) SubscribeViewers(ctx context.Context, postID int64) (*Subscription, error) {
+	// Changes sent before the current presence is queued are newer, and the
+	// subscriber skips it then.
+	s.presence.mu.Lock()
+	sub := newSubscriber(topic{kind: topicViewers, id: postID}, nil)
+	subscription, err := s.subscribe(ctx, sub)
+	if err != nil {
+		s.presence.mu.Unlock()
+		return nil, err
+	}
+	current := s.presenceLocked(postID, time.Now())
+	s.presence.mu.Unlock()
+	sub.enqueue(current, s.opts)
+	return subscription, nil
+}
+
+// SetTyping shows authorID as typing a comment on postID for TypingTTL.
+func (s *SubscriptionService) SetTyping(ctx context.Context, postID int64, authorID uuid.UUID) error {
+	return s.broker.Publish(ctx, &Event{Kind: EventTyping, PostID: postID, Instance: s.presence.instance, Author: &authorID})
+}
+
+func (s *SubscriptionService) localViewers(postID int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers[topic{kind: topicComments, id: postID}])
+}
+
+func (s *SubscriptionService) viewersLocked(postID int64, now time.Time) int {
+	n := s.localViewers(postID)
+	for _, r := range s.presence.remote[postID] {
+		if now.Sub(r.seen) < s.remoteTTL() {
+			n += r.count
+		}
+	}
+	return n
+}
+
+func (s *SubscriptionService) remoteTTL() time.Duration {
+	return 3 * s.opts.PresenceInterval
+}
+
+func (s *SubscriptionService) presenceLocked(postID int64, now time.Time) *Event {
+	typing := make([]uuid.UUID, 0, len(s.presence.typing[postID]))
+	for author := range s.presence.typing[postID] {
+		typing = append(typing, author)
+	}
+	slices.SortFunc(typing, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	s.presence.seq++
+	return &Event{Kind: EventPresenceChanged, PostID: postID, seq: s.presence.seq, Presence: &model.Presence{
+		PostID:  postID,
+		Viewers: s.viewersLocked(postID, now),
+		Typing:  typing,
+	}}
+}
+
+// presenceChangedLocked returns the presence of postID for its subscribers,
+// or nil if it has none. The event is sent with sendPresence once the
+// presence lock is released, so a slow subscriber doesn't hold it.
+func (s *SubscriptionService) presenceChangedLocked(postID int64, now time.Time) *Event {
+	s.mu.Lock()
+	watched := len(s.subscribers[topic{kind: topicViewers, id: postID}]) > 0
+	s.mu.Unlock()
+	if !watched {
+		return nil
+	}
+	return s.presenceLocked(postID, now)
+}
+
+func (s *SubscriptionService) sendPresence(events ...*Event) {
+	for _, event := range events {
+		if event != nil {
+			s.fanOut(event)
+		}
+	}
+}
+
+// localViewersChanged is called after a commentAdded subscriber of postID
+// came or went. It must not be called with s.mu held.
+func (s *SubscriptionService) localViewersChanged(postID int64) {
+	s.presence.mu.Lock()
+	s.presence.changed[postID] = struct{}{}
+	event := s.presenceChangedLocked(postID, time.Now())
+	s.presence.mu.Unlock()
+	s.sendPresence(event)
+	signal(s.presence.announce)
+}
+
+// receivePresence applies the presence events from the broker and reports
+// whether event was one.
+func (s *SubscriptionService) receivePresence(event *Event) bool {
+	switch event.Kind {
+	case EventViewers:
+		if event.Instance != s.presence.instance {
+			s.remoteViewersChanged(event)
+		}
+		return true
+	case EventTyping:
+		if event.Author != nil {
+			s.typingStarted(event.PostID, *event.Author)
+		}
+		return true
+	case EventCommentAdded:
+		if event.Comment != nil {
+			s.typingStopped(event.PostID, event.Comment.AuthorID)
+		}
+	}
+	return false
+}
+
+func (s *SubscriptionService) remoteViewersChanged(event *Event) {
+	s.presence.mu.Lock()
+	var changed *Event
+	defer func() {
+		s.presence.mu.Unlock()
+		s.sendPresence(changed)
+	}()
+
+	now := time.Now()
+	counts := s.presence.remote[event.PostID]
+	prev := 0
+	if r, ok := counts[event.Instance]; ok && now.Sub(r.seen) < s.remoteTTL() {
+		prev = r.count
+	}
+	if event.Viewers > 0 {
+		if counts == nil {
+			counts = make(map[string]remoteViewers)
+			s.presence.remote[event.PostID] = counts
+		}
+		counts[event.Instance] = remoteViewers{count: event.Viewers, seen: now}
+	} else {
+		delete(counts, event.Instance)
+		if len(counts) == 0 {
+			delete(s.presence.remote, event.PostID)
+		}
+	}
+	if prev != event.Viewers {
+		changed = s.presenceChangedLocked(event.PostID, now)
+	}
+}
+
+func (s *SubscriptionService) typingStarted(postID int64, author uuid.UUID) {
+	s.presence.mu.Lock()
+	now := time.Now()
+	authors, ok := s.presence.typing[postID]
+	if !ok {
+		authors = make(map[uuid.UUID]time.Time)
+		s.presence.typing[postID] = authors
+	}
+	_, typing := authors[author]
+	authors[author] = now.Add(s.opts.TypingTTL)
+	var changed *Event
+	if !typing {
+		changed = s.presenceChangedLocked(postID, now)
+	}
+	s.presence.mu.Unlock()
+	s.sendPresence(changed)
+}
+
+func (s *SubscriptionService) typingStopped(postID int64, author uuid.UUID) {
+	s.presence.mu.Lock()
+	authors := s.presence.typing[postID]
+	if _, ok := authors[author]; !ok {
+		s.presence.mu.Unlock()
+		return
+	}
+	delete(authors, author)
+	if len(authors) == 0 {
+		delete(s.presence.typing, postID)
+	}
+	changed := s.presenceChangedLocked(postID, time.Now())
+	s.presence.mu.Unlock()
+	s.sendPresence(changed)
+}
+
+// maintainPresence announces the local viewer counts and expires stale
+// presence until the service is closed.
+func (s *SubscriptionService) maintainPresence(ctx context.Context) {
+	heartbeat := time.NewTicker(s.opts.PresenceInterval)
+	defer heartbeat.Stop()
+	expire := time.NewTicker(min(s.opts.PresenceInterval, s.opts.TypingTTL) / 5)
+	defer expire.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.presence.stop:
+			return
+		case <-s.presence.announce:
+			s.announceViewers(ctx, s.takeChangedPosts())
+		case <-heartbeat.C:
+			s.announceViewers(ctx, s.watchedPosts())
+		case now := <-expire.C:
+			s.expirePresence(now)
+		}
+	}
+}
+
+func (s *SubscriptionService) takeChangedPosts() []int64 {
+	s.presence.mu.Lock()
+	defer s.presence.mu.Unlock()
+	posts := make([]int64, 0, len(s.presence.changed))
+	for postID := range s.presence.changed {
+		posts = append(posts, postID)
+	}
+	clear(s.presence.changed)
+	return posts
+}
+
+func (s *SubscriptionService) watchedPosts() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var posts []int64
+	for t := range s.subscribers {
+		if t.kind == topicComments {
+			posts = append(posts, t.id)
+		}
+	}
+	return posts
+}
+
+// announceViewers publishes the local viewer counts of posts. Counts are
+// only announced from here, one at a time, so other instances get them in
+// order.
+func (s *SubscriptionService) announceViewers(ctx context.Context, posts []int64) {
+	for _, postID := range posts {
+		event := &Event{Kind: EventViewers, PostID: postID, Instance: s.presence.instance, Viewers: s.localViewers(postID)}
+		publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := s.broker.Publish(publishCtx, event)
+		cancel()
+		if err != nil {
+			log.FromContext(ctx).Named(log.PackageService).Warn("Failed to announce viewers", zap.Error(err), zap.Int64("post_id", postID))
+		}
+	}
+}
+
+func (s *SubscriptionService) expirePresence(now time.Time) {
+	s.presence.mu.Lock()
+	var events []*Event
+	defer func() {
+		s.presence.mu.Unlock()
+		s.sendPresence(events...)
+	}()
+
+	changed := make(map[int64]struct{})
+	for postID, counts := range s.presence.remote {
+		for instance, r := range counts {
+			if now.Sub(r.seen) >= s.remoteTTL() {
+				delete(counts, instance)
+				changed[postID] = struct{}{}
+			}
+		}
+		if len(counts) == 0 {
+			delete(s.presence.remote, postID)
+		}
+	}
+	for postID, authors := range s.presence.typing {
+		for author, until := range authors {
+			if !now.Before(until) {
+				delete(authors, author)
+				changed[postID] = struct{}{}
+			}
+		}
+		if len(authors) == 0 {
+			delete(s.presence.typing, postID)
+		}
+	}
+	for postID := range changed {
+		events = append(events, s.presenceChangedLocked(postID, now))
+	}
+}
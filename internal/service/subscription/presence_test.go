@@ -0,0 +1,192 @@
+package subscription_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hub connects the brokers of several instances in one process.
+type hub struct {
+	mu      sync.Mutex
+	brokers []*hubBroker
+}
+
+type hubBroker struct {
+	hub    *hub
+	handle func(*subscription.Event)
+	// cut makes the instance stop publishing, as if it went away.
+	cut bool
+}
+
+func (h *hub) broker() *hubBroker {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := &hubBroker{hub: h}
+	h.brokers = append(h.brokers, b)
+	return b
+}
+
+func (b *hubBroker) Publish(ctx context.Context, event *subscription.Event) error {
+	b.hub.mu.Lock()
+	if b.cut {
+		b.hub.mu.Unlock()
+		return errors.New("instance is gone")
+	}
+	var handlers []func(*subscription.Event)
+	for _, other := range b.hub.brokers {
+		if other.handle != nil {
+			handlers = append(handlers, other.handle)
+		}
+	}
+	b.hub.mu.Unlock()
+	for _, handle := range handlers {
+		handle(event)
+	}
+	return nil
+}
+
+func (b *hubBroker) Listen(ctx context.Context, handle func(*subscription.Event)) error {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	b.handle = handle
+	return nil
+}
+
+func (b *hubBroker) Close() error {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	b.handle = nil
+	return nil
+}
+
+func (b *hubBroker) goAway() {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	b.cut = true
+	b.handle = nil
+}
+
+func newInstance(t *testing.T, broker subscription.Broker, opts subscription.Options) *subscription.SubscriptionService {
+	svc, err := subscription.NewSubscriptionService(context.Background(), broker, opts)
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+	return svc
+}
+
+// nextPresence reads the next presence of sub.
+func nextPresence(t *testing.T, sub *subscription.Subscription) *model.Presence {
+	t.Helper()
+	select {
+	case event, ok := <-sub.C():
+		require.True(t, ok, "subscription ended")
+		require.Equal(t, subscription.EventPresenceChanged, event.Kind)
+		return event.Presence
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for presence")
+		return nil
+	}
+}
+
+func TestViewersCountsCommentSubscribers(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	viewers, err := svc.SubscribeViewers(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, &model.Presence{PostID: 1, Viewers: 0, Typing: []uuid.UUID{}}, nextPresence(t, viewers), "the current presence comes first")
+
+	_, stop := subscribe(t, svc, 1)
+	subscribe(t, svc, 1)
+	subscribe(t, svc, 2)
+	_, err = svc.SubscribePostActivity(ctx, 1)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, nextPresence(t, viewers).Viewers)
+	assert.Equal(t, 2, nextPresence(t, viewers).Viewers)
+	assert.Equal(t, 2, svc.Viewers(1), "only commentAdded subscribers are viewers")
+
+	stop()
+	assert.Equal(t, 1, nextPresence(t, viewers).Viewers)
+	assert.Equal(t, 1, svc.Viewers(1))
+}
+
+func TestTypingExpiresAndStopsWithComment(t *testing.T) {
+	svc := newService(t, subscription.Options{TypingTTL: 100 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	viewers, err := svc.SubscribeViewers(ctx, 1)
+	require.NoError(t, err)
+	nextPresence(t, viewers)
+
+	author := uuid.New()
+	require.NoError(t, svc.SetTyping(ctx, 1, author))
+	assert.Equal(t, []uuid.UUID{author}, nextPresence(t, viewers).Typing)
+	require.NoError(t, svc.SetTyping(ctx, 2, uuid.New()), "typing on other posts is not sent")
+
+	start := time.Now()
+	assert.Empty(t, nextPresence(t, viewers).Typing)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "typing lasts until its TTL")
+
+	require.NoError(t, svc.SetTyping(ctx, 1, author))
+	assert.Equal(t, []uuid.UUID{author}, nextPresence(t, viewers).Typing)
+	require.NoError(t, svc.Publish(ctx, subscription.CommentAdded(&model.Comment{ID: 1, PostID: 1, AuthorID: author})))
+	assert.Empty(t, nextPresence(t, viewers).Typing, "adding a comment stops typing")
+}
+
+func TestPresenceIsSharedBetweenInstances(t *testing.T) {
+	h := &hub{}
+	opts := subscription.Options{PresenceInterval: 50 * time.Millisecond}
+	brokerA := h.broker()
+	a := newInstance(t, brokerA, opts)
+	b := newInstance(t, h.broker(), opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	viewers, err := b.SubscribeViewers(ctx, 1)
+	require.NoError(t, err)
+	nextPresence(t, viewers)
+
+	subscribe(t, a, 1)
+	subscribe(t, a, 1)
+	subscribe(t, b, 1)
+	assert.Eventually(t, func() bool { return a.Viewers(1) == 3 && b.Viewers(1) == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	author := uuid.New()
+	require.NoError(t, a.SetTyping(ctx, 1, author))
+	p := nextPresence(t, viewers)
+	for len(p.Typing) == 0 {
+		p = nextPresence(t, viewers)
+	}
+	assert.Equal(t, []uuid.UUID{author}, p.Typing, "typing on one instance reaches the others")
+
+	brokerA.goAway()
+	assert.Eventually(t, func() bool { return b.Viewers(1) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"the viewers of an instance that went away expire")
+}
+
+func TestSlowPresenceSubscriberDoesNotHoldPresence(t *testing.T) {
+	svc := newService(t, subscription.Options{Buffer: 1, Policy: subscription.PolicyBlock, BlockTimeout: 2 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// The subscriber never reads: its first presence waits on the channel
+	// and the next one fills its buffer.
+	_, err := svc.SubscribeViewers(ctx, 1)
+	require.NoError(t, err)
+	subscribe(t, svc, 1)
+	go svc.Subscribe(ctx, 1)
+
+	assert.Eventually(t, func() bool { return svc.Viewers(1) == 2 }, time.Second, 10*time.Millisecond,
+		"presence can be read while a change waits for the slow subscriber")
+	other, err := svc.SubscribeViewers(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, nextPresence(t, other).Viewers)
+}
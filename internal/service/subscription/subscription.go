@@ -1,64 +1,636 @@
 package subscription
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"go.uber.org/zap"
 )
 
+// Policy decides what happens when an event arrives for a subscriber whose
+// buffer is full.
+type Policy string
+
+const (
+	// PolicyDropOldest discards the oldest buffered event to make room.
+	PolicyDropOldest Policy = "drop-oldest"
+	// PolicyDisconnect ends the subscription with ErrSlowConsumer.
+	PolicyDisconnect Policy = "disconnect"
+	// PolicyBlock makes delivery wait up to BlockTimeout for room and then
+	// ends the subscription with ErrSlowConsumer. While it waits, events for
+	// other subscribers are held back too.
+	PolicyBlock Policy = "block"
+)
+
+var (
+	ErrSlowConsumer = errors.New("subscription ended: client did not keep up with new events")
+	ErrClosed       = errors.New("subscription service is shutting down")
+	ErrReplayFailed = errors.New("subscription ended: failed to load missed comments")
+
+	errEnded = errors.New("subscriber ended")
+)
+
+// replayPage is how many stored comments a resumed subscription loads at a
+// time.
+const replayPage = 100
+
+// LoadFunc returns up to limit comments on postID with IDs above afterID, in
+// ID order.
+type LoadFunc func(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error)
+
+// Options configures delivery to each subscriber and presence tracking.
+// Zero values are replaced by the defaults.
+type Options struct {
+	Buffer       int
+	Policy       Policy
+	BlockTimeout time.Duration
+	// PresenceInterval is how often viewer counts are announced to other
+	// instances even if they did not change.
+	PresenceInterval time.Duration
+	// TypingTTL is how long a typing indicator lasts unless it is set
+	// again.
+	TypingTTL time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Buffer <= 0 {
+		o.Buffer = 64
+	}
+	if o.Policy == "" {
+		o.Policy = PolicyDropOldest
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = time.Second
+	}
+	if o.PresenceInterval <= 0 {
+		o.PresenceInterval = 10 * time.Second
+	}
+	if o.TypingTTL <= 0 {
+		o.TypingTTL = 5 * time.Second
+	}
+	return o
+}
+
 type SubscriptionService struct {
-	Subscribers map[int64][]chan *model.Comment
-	mu          sync.Mutex
+	subscribers     map[topic][]*subscriber
+	presence        *presence
+	broker          Broker
+	opts            Options
+	closed          bool
+	dropped         atomic.Uint64
+	droppedComments atomic.Uint64
+	mu              sync.Mutex
+
+	// watched counts the subscribers of each post, or of AllPosts, when
+	// the broker is a PostWatcher. watchMu is held while the broker starts
+	// or stops watching, so the calls for a post can't overtake each other.
+	watcher PostWatcher
+	watched map[int64]int
+	watchMu sync.Mutex
 }
 
-func NewSubscriptionService() *SubscriptionService {
-	return &SubscriptionService{
-		Subscribers: make(map[int64][]chan *model.Comment),
+// NewSubscriptionService fans out the events that broker receives from
+// every instance to the subscribers of this one, and shares the presence on
+// posts with the other instances.
+func NewSubscriptionService(ctx context.Context, broker Broker, opts Options) (*SubscriptionService, error) {
+	s := &SubscriptionService{
+		subscribers: make(map[topic][]*subscriber),
+		presence:    newPresence(),
+		broker:      broker,
+		opts:        opts.withDefaults(),
 		mu:          sync.Mutex{},
+		watched:     make(map[int64]int),
 	}
+	s.watcher, _ = broker.(PostWatcher)
+	if err := broker.Listen(ctx, s.deliver); err != nil {
+		return nil, err
+	}
+	go s.maintainPresence(ctx)
+	return s, nil
 }
 
-func (s *SubscriptionService) Subscribe(postID int64, channel chan *model.Comment) {
+// Subscription is a stream of events. C is closed when ctx passed to
+// Subscribe is done, the subscriber falls behind under the disconnect or
+// block policy, or the service is closed; Err tells which.
+type Subscription struct {
+	sub *subscriber
+}
+
+func (s *Subscription) C() <-chan *Event {
+	return s.sub.out
+}
+
+// Err returns ErrSlowConsumer if the subscription was ended for falling
+// behind, ErrReplayFailed if missed comments could not be loaded and nil
+// otherwise. It is final once C is closed.
+func (s *Subscription) Err() error {
+	s.sub.mu.Lock()
+	defer s.sub.mu.Unlock()
+	return s.sub.err
+}
+
+// Subscribe starts a subscription to comments added to postID that lasts
+// until ctx is done.
+func (s *SubscriptionService) Subscribe(ctx context.Context, postID int64) (*Subscription, error) {
+	return s.subscribe(ctx, newSubscriber(topic{kind: topicComments, id: postID}, nil))
+}
+
+// SubscribeSince resumes a subscription to comments on postID after the
+// comment with ID since: comments stored after it are loaded with load and
+// sent first, then the subscription continues with live comments. Comments
+// published while the replay runs are held back and those already replayed
+// are skipped, so none is missed or sent twice.
+func (s *SubscriptionService) SubscribeSince(ctx context.Context, postID, since int64, load LoadFunc) (*Subscription, error) {
+	return s.subscribe(ctx, newSubscriber(topic{kind: topicComments, id: postID}, &replay{since: since, load: load}))
+}
+
+// SubscribePostActivity starts a subscription to every event of postID.
+func (s *SubscriptionService) SubscribePostActivity(ctx context.Context, postID int64) (*Subscription, error) {
+	return s.subscribe(ctx, newSubscriber(topic{kind: topicPost, id: postID}, nil))
+}
+
+// SubscribePostsCreated starts a subscription to new posts.
+func (s *SubscriptionService) SubscribePostsCreated(ctx context.Context) (*Subscription, error) {
+	return s.subscribe(ctx, newSubscriber(topic{kind: topicPosts}, nil))
+}
+
+// SubscribeReplies starts a subscription to replies added at any depth
+// below commentID.
+func (s *SubscriptionService) SubscribeReplies(ctx context.Context, commentID int64) (*Subscription, error) {
+	return s.subscribe(ctx, newSubscriber(topic{kind: topicReplies, id: commentID}, nil))
+}
+
+// SubscribeAuthor starts a subscription to comments added by authorID.
+func (s *SubscriptionService) SubscribeAuthor(ctx context.Context, authorID uuid.UUID) (*Subscription, error) {
+	return s.subscribe(ctx, newSubscriber(topic{kind: topicAuthor, author: authorID}, nil))
+}
+
+func (s *SubscriptionService) subscribe(ctx context.Context, sub *subscriber) (*Subscription, error) {
+	// The subscriber is registered before a replay starts so that comments
+	// stored after the replay's last read still reach it live.
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.Subscribers[postID] = append(s.Subscribers[postID], channel)
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrClosed
+	}
+	s.subscribers[sub.topic] = append(s.subscribers[sub.topic], sub)
+	s.mu.Unlock()
+	if err := s.watch(ctx, sub.topic.post()); err != nil {
+		s.remove(sub)
+		return nil, err
+	}
+	if sub.topic.kind == topicComments {
+		s.localViewersChanged(sub.topic.id)
+	}
+
+	go s.run(ctx, sub)
+	return &Subscription{sub: sub}, nil
+}
+
+// watch makes the broker receive the events of postID while it has
+// subscribers on this instance.
+func (s *SubscriptionService) watch(ctx context.Context, postID int64) error {
+	if s.watcher == nil {
+		return nil
+	}
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.watched[postID] == 0 {
+		watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := s.watcher.WatchPost(watchCtx, postID); err != nil {
+			log.FromContext(ctx).Named(log.PackageService).Error("Failed to watch post events", zap.Error(err), zap.Int64("post_id", postID))
+			return err
+		}
+	}
+	s.watched[postID]++
+	return nil
+}
+
+func (s *SubscriptionService) unwatch(ctx context.Context, postID int64) {
+	if s.watcher == nil {
+		return
+	}
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.watched[postID]--; s.watched[postID] > 0 {
+		return
+	}
+	delete(s.watched, postID)
+	if !s.Running() {
+		return
+	}
+	unwatchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.watcher.UnwatchPost(unwatchCtx, postID); err != nil {
+		log.FromContext(ctx).Named(log.PackageService).Warn("Failed to stop watching post events", zap.Error(err), zap.Int64("post_id", postID))
+	}
+}
+
+// Publish hands the event to the broker, which delivers it to the
+// subscribers of its topics on all instances.
+func (s *SubscriptionService) Publish(ctx context.Context, event *Event) error {
+	return s.broker.Publish(ctx, event)
 }
 
-func (s *SubscriptionService) Publish(postID int64, comment *model.Comment) {
+// Deliver publishes an event of the outbox, which makes the service a sink
+// of the outbox relay. Events of kinds it doesn't know are skipped.
+func (s *SubscriptionService) Deliver(ctx context.Context, event *storage.OutboxEvent) error {
+	if e := FromOutbox(event); e != nil {
+		return s.broker.Publish(ctx, e)
+	}
+	return nil
+}
+
+// deliver handles an event received from the broker.
+func (s *SubscriptionService) deliver(event *Event) {
+	if s.receivePresence(event) {
+		return
+	}
+	s.fanOut(event)
+}
+
+// fanOut queues event for every local subscriber of its topics. The lock is
+// only held to copy the subscriber lists, so a slow subscriber can't stall
+// Subscribe or the end of other subscriptions.
+func (s *SubscriptionService) fanOut(event *Event) {
+	var subs []*subscriber
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	for _, t := range event.topics() {
+		subs = append(subs, s.subscribers[t]...)
+	}
+	s.mu.Unlock()
 
-	if channels, ok := s.Subscribers[postID]; ok {
-		var activeChannels []chan *model.Comment
+	for _, sub := range subs {
+		switch sub.enqueue(event, s.opts) {
+		case droppedComment:
+			s.droppedComments.Add(1)
+		case disconnected:
+			s.dropped.Add(1)
+		}
+	}
+}
 
-		for _, ch := range channels {
+// run replays missed comments if the subscription was resumed and then
+// forwards the subscriber's queue to its channel. It is the only place the
+// channel is closed.
+func (s *SubscriptionService) run(ctx context.Context, sub *subscriber) {
+	defer func() {
+		s.remove(sub)
+		sub.end(nil)
+		close(sub.out)
+		s.unwatch(ctx, sub.topic.post())
+	}()
+	var seen *replayed
+	if sub.replay != nil {
+		var err error
+		if seen, err = s.replay(ctx, sub); err != nil {
+			if ctx.Err() == nil && !errors.Is(err, errEnded) {
+				log.FromContext(ctx).Named(log.PackageService).Error("Failed to replay comments",
+					zap.Error(err), zap.Int64("post_id", sub.topic.id), zap.Int64("since", sub.replay.since))
+				sub.end(ErrReplayFailed)
+			}
+			return
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.done:
+			return
+		case <-sub.wake:
+		}
+		for {
+			event, ok := sub.pop()
+			if !ok {
+				break
+			}
+			if event.Kind == EventCommentAdded && seen.skip(event.CommentID) {
+				continue
+			}
 			select {
-			case ch <- comment:
-				activeChannels = append(activeChannels, ch)
-			default:
-				close(ch)
+			case sub.out <- event:
+			case <-ctx.Done():
+				return
+			case <-sub.done:
+				return
 			}
 		}
+	}
+}
 
-		s.Subscribers[postID] = activeChannels
+// replay sends the stored comments after sub.replay.since, page by page,
+// until it has caught up with the comments held back in the queue. If the
+// queue overflowed meanwhile, the overflowing comments are loaded again
+// from storage instead of being lost.
+func (s *SubscriptionService) replay(ctx context.Context, sub *subscriber) (*replayed, error) {
+	seen := &replayed{ids: make(map[int64]struct{})}
+	after := sub.replay.since
+	for {
+		comments, err := sub.replay.load(ctx, sub.topic.id, after, replayPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			after = comment.ID
+			if _, ok := seen.ids[comment.ID]; ok {
+				continue
+			}
+			select {
+			case sub.out <- CommentAdded(comment):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-sub.done:
+				return nil, errEnded
+			}
+			seen.add(comment.ID)
+		}
+		if len(comments) == replayPage {
+			continue
+		}
+		if from, ok := sub.finishReplay(); !ok {
+			after = from
+			continue
+		}
+		return seen, nil
+	}
+}
+
+func (s *SubscriptionService) remove(sub *subscriber) {
+	s.mu.Lock()
+	subs := s.subscribers[sub.topic]
+	for i, other := range subs {
+		if other == sub {
+			s.subscribers[sub.topic] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(s.subscribers[sub.topic]) == 0 {
+		delete(s.subscribers, sub.topic)
+	}
+	s.mu.Unlock()
+	if sub.topic.kind == topicComments {
+		s.localViewersChanged(sub.topic.id)
 	}
 }
 
-func (s *SubscriptionService) Unsubscribe(postID int64, ch chan *model.Comment) {
+// Close stops the broker, ends every active subscription and rejects new
+// ones.
+func (s *SubscriptionService) Close() {
+	s.broker.Close()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if channels, ok := s.Subscribers[postID]; ok {
-		for i, channel := range channels {
-			if channel == ch {
-				close(ch)
-				s.Subscribers[postID] = append(channels[:i], channels[i+1:]...)
-				break
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.presence.stop)
+	for t, subs := range s.subscribers {
+		for _, sub := range subs {
+			sub.end(nil)
+		}
+		delete(s.subscribers, t)
+	}
+}
+
+func (s *SubscriptionService) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed
+}
+
+// SubscriberCounts returns the number of commentAdded subscribers of each
+// post; subscribers of other topics are not counted.
+func (s *SubscriptionService) SubscriberCounts() map[int64]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[int64]int, len(s.subscribers))
+	for t, subs := range s.subscribers {
+		if t.kind == topicComments {
+			counts[t.id] += len(subs)
+		}
+	}
+	return counts
+}
+
+// Dropped returns how many subscribers were disconnected because they could
+// not keep up with published events.
+func (s *SubscriptionService) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// DroppedComments returns how many buffered events were discarded under the
+// drop-oldest policy.
+func (s *SubscriptionService) DroppedComments() uint64 {
+	return s.droppedComments.Load()
+}
+
+type enqueueResult int
+
+const (
+	queued enqueueResult = iota
+	droppedComment
+	disconnected
+	ignored
+)
+
+type subscriber struct {
+	topic  topic
+	replay *replay
+	out    chan *Event
+	// wake and space hold at most one pending signal: the queue has
+	// grown, the queue has shrunk.
+	wake  chan struct{}
+	space chan struct{}
+	done  chan struct{}
+
+	mu    sync.Mutex
+	queue []*Event
+	ended bool
+	err   error
+	// replaying is set while a resumed subscription loads missed comments.
+	// Comments that overflow the queue meanwhile are dropped and reloaded
+	// from after resyncFrom; the queue only holds added comments then.
+	replaying  bool
+	resync     bool
+	resyncFrom int64
+	// presenceSeq is the seq of the latest presence event queued; older
+	// ones are skipped.
+	presenceSeq uint64
+}
+
+type replay struct {
+	since int64
+	load  LoadFunc
+}
+
+func newSubscriber(t topic, r *replay) *subscriber {
+	return &subscriber{
+		topic:     t,
+		replay:    r,
+		replaying: r != nil,
+		out:       make(chan *Event),
+		wake:      make(chan struct{}, 1),
+		space:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+func (sub *subscriber) enqueue(event *Event, opts Options) enqueueResult {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.ended || sub.outdated(event) {
+		return ignored
+	}
+	result := queued
+	if len(sub.queue) >= opts.Buffer && sub.replaying {
+		// The comment is stored, so the replay loads it again instead.
+		if !sub.resync || sub.queue[0].CommentID-1 < sub.resyncFrom {
+			sub.resync = true
+			sub.resyncFrom = sub.queue[0].CommentID - 1
+		}
+		sub.queue = sub.queue[1:]
+	} else if len(sub.queue) >= opts.Buffer {
+		switch opts.Policy {
+		case PolicyDropOldest:
+			sub.queue = sub.queue[1:]
+			result = droppedComment
+		case PolicyBlock:
+			if !sub.waitForSpace(opts.Buffer, opts.BlockTimeout) {
+				if sub.ended {
+					return ignored
+				}
+				sub.endLocked(ErrSlowConsumer)
+				return disconnected
 			}
+			// A newer presence may have been queued while waiting.
+			if sub.outdated(event) {
+				return ignored
+			}
+		default:
+			sub.endLocked(ErrSlowConsumer)
+			return disconnected
 		}
+	}
+	sub.queue = append(sub.queue, event)
+	sub.presenceSeq = max(sub.presenceSeq, event.seq)
+	signal(sub.wake)
+	return result
+}
+
+func (sub *subscriber) outdated(event *Event) bool {
+	return event.seq != 0 && event.seq <= sub.presenceSeq
+}
 
-		if len(s.Subscribers[postID]) == 0 {
-			delete(s.Subscribers, postID)
+// waitForSpace releases sub.mu until the queue has room, the subscriber
+// ends or timeout passes, and reports whether there is room.
+func (sub *subscriber) waitForSpace(buffer int, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for len(sub.queue) >= buffer && !sub.ended {
+		sub.mu.Unlock()
+		select {
+		case <-sub.space:
+		case <-sub.done:
+		case <-timer.C:
+			sub.mu.Lock()
+			return len(sub.queue) < buffer && !sub.ended
 		}
+		sub.mu.Lock()
+	}
+	return !sub.ended
+}
+
+func (sub *subscriber) pop() (*Event, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if len(sub.queue) == 0 {
+		return nil, false
+	}
+	event := sub.queue[0]
+	sub.queue[0] = nil
+	sub.queue = sub.queue[1:]
+	signal(sub.space)
+	return event, true
+}
+
+// finishReplay switches the subscriber to live delivery and reports true,
+// unless comments were dropped from the queue during the replay: then it
+// returns the ID to reload them after and false.
+func (sub *subscriber) finishReplay() (int64, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.resync {
+		sub.resync = false
+		return sub.resyncFrom, false
+	}
+	sub.replaying = false
+	return 0, true
+}
+
+func (sub *subscriber) end(err error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.endLocked(err)
+}
+
+// endLocked marks the subscriber as ended; the first reason wins.
+func (sub *subscriber) endLocked(err error) {
+	if sub.ended {
+		return
+	}
+	sub.ended = true
+	sub.err = err
+	sub.queue = nil
+	close(sub.done)
+}
+
+// replayed remembers the comments a replay sent so that their live copies
+// are skipped. Comments are published in about the order they are created,
+// so once a live comment newer than all of them is sent, the rest are not
+// expected anymore and are forgotten.
+type replayed struct {
+	ids map[int64]struct{}
+	max int64
+}
+
+func (r *replayed) add(id int64) {
+	r.ids[id] = struct{}{}
+	r.max = max(r.max, id)
+}
+
+// skip reports whether the live comment id was already replayed.
+func (r *replayed) skip(id int64) bool {
+	if r == nil || r.ids == nil {
+		return false
+	}
+	if _, ok := r.ids[id]; ok {
+		delete(r.ids, id)
+		return true
+	}
+	if id > r.max {
+		r.ids = nil
+	}
+	return false
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
 	}
 }
@@ -1,6 +1,9 @@
 package subscription_test
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -8,14 +11,52 @@ import (
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newService(t testing.TB, opts subscription.Options) *subscription.SubscriptionService {
+	svc, err := subscription.NewSubscriptionService(context.Background(), subscription.NewMemoryBroker(), opts)
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+	return svc
+}
+
+func subscribe(t testing.TB, svc *subscription.SubscriptionService, postID int64) (*subscription.Subscription, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sub, err := svc.Subscribe(ctx, postID)
+	require.NoError(t, err)
+	return sub, cancel
+}
+
+func publish(t testing.TB, svc *subscription.SubscriptionService, postID int64, ids ...int64) {
+	for _, id := range ids {
+		require.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(&model.Comment{ID: id, PostID: postID, AuthorID: uuid.New()})))
+	}
+}
+
+// drain reads the subscription until it is closed.
+func drain(t testing.TB, sub *subscription.Subscription) []int64 {
+	var ids []int64
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-sub.C():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, event.CommentID)
+		case <-timeout:
+			t.Fatal("timeout: subscription was not closed")
+			return nil
+		}
+	}
+}
+
 func TestSubscribeAndPublish(t *testing.T) {
-	svc := subscription.NewSubscriptionService()
+	svc := newService(t, subscription.Options{})
 	postID := int64(42)
-	ch := make(chan *model.Comment, 1)
-
-	svc.Subscribe(postID, ch)
+	sub, _ := subscribe(t, svc, postID)
 
 	comment := &model.Comment{
 		ID:       1,
@@ -24,74 +65,504 @@ func TestSubscribeAndPublish(t *testing.T) {
 		Content:  "Nice post!",
 	}
 
-	svc.Publish(postID, comment)
+	require.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(comment)))
 
 	select {
-	case received := <-ch:
-		assert.Equal(t, comment, received)
+	case received := <-sub.C():
+		assert.Equal(t, comment, received.Comment)
 	case <-time.After(time.Second):
 		t.Fatal("timeout: no comment received on channel")
 	}
 }
 
 func TestUnsubscribe(t *testing.T) {
-	svc := subscription.NewSubscriptionService()
+	svc := newService(t, subscription.Options{})
 	postID := int64(100)
-	ch := make(chan *model.Comment, 1)
+	sub, cancel := subscribe(t, svc, postID)
+
+	cancel()
+	assert.Empty(t, drain(t, sub))
+	assert.NoError(t, sub.Err())
+
+	publish(t, svc, postID, 2)
+	assert.Eventually(t, func() bool { return len(svc.SubscriberCounts()) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestPublishToMultipleSubscribers(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	postID := int64(77)
 
-	svc.Subscribe(postID, ch)
-	svc.Unsubscribe(postID, ch)
+	sub1, _ := subscribe(t, svc, postID)
+	sub2, _ := subscribe(t, svc, postID)
 
 	comment := &model.Comment{
-		ID:       2,
+		ID:       3,
 		PostID:   postID,
 		AuthorID: uuid.New(),
-		Content:  "Should not be received",
+		Content:  "Multicast!",
 	}
 
-	svc.Publish(postID, comment)
+	require.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(comment)))
 
 	select {
-	case msg, ok := <-ch:
-		if ok {
-			t.Fatalf("expected channel to be closed, but received: %+v", msg)
-		}
-		// ok == false means the channel was closed, which is expected
-	case <-time.After(100 * time.Millisecond):
-		t.Fatal("timeout: expected closed channel but nothing happened")
-	} // expected: channel is closed and ignored
+	case msg := <-sub1.C():
+		assert.Equal(t, comment, msg.Comment)
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for sub1")
+	}
+
+	select {
+	case msg := <-sub2.C():
+		assert.Equal(t, comment, msg.Comment)
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for sub2")
+	}
 }
 
-func TestPublishToMultipleSubscribers(t *testing.T) {
-	svc := subscription.NewSubscriptionService()
-	postID := int64(77)
+func TestCloseEndsSubscriptions(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	sub, cancel := subscribe(t, svc, 1)
 
-	ch1 := make(chan *model.Comment, 1)
-	ch2 := make(chan *model.Comment, 1)
+	svc.Close()
 
-	svc.Subscribe(postID, ch1)
-	svc.Subscribe(postID, ch2)
+	assert.Empty(t, drain(t, sub))
+	assert.NoError(t, sub.Err())
+	assert.False(t, svc.Running())
 
-	comment := &model.Comment{
-		ID:       3,
-		PostID:   postID,
-		AuthorID: uuid.New(),
-		Content:  "Multicast!",
+	_, err := svc.Subscribe(context.Background(), 1)
+	assert.ErrorIs(t, err, subscription.ErrClosed, "subscriptions after Close must be rejected")
+
+	// Ending an already closed subscription must not close its channel again.
+	cancel()
+	svc.Close()
+}
+
+func TestSlowConsumer_DropOldestKeepsNewest(t *testing.T) {
+	svc := newService(t, subscription.Options{Buffer: 2, Policy: subscription.PolicyDropOldest})
+	sub, cancel := subscribe(t, svc, 1)
+
+	publish(t, svc, 1, 1, 2, 3, 4, 5, 6)
+	var got []int64
+	for len(got) == 0 || got[len(got)-1] != 6 {
+		select {
+		case event := <-sub.C():
+			got = append(got, event.CommentID)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout: newest comment not delivered, got %v", got)
+		}
 	}
+	cancel()
+
+	assert.IsIncreasing(t, got)
+	assert.Equal(t, uint64(6-len(got)), svc.DroppedComments())
+	assert.NoError(t, sub.Err())
+	assert.Zero(t, svc.Dropped())
+}
+
+func TestSlowConsumer_Disconnect(t *testing.T) {
+	svc := newService(t, subscription.Options{Buffer: 1, Policy: subscription.PolicyDisconnect})
+	sub, _ := subscribe(t, svc, 1)
+	other, _ := subscribe(t, svc, 2)
 
-	svc.Publish(postID, comment)
+	publish(t, svc, 1, 1, 2, 3, 4)
 
+	got := drain(t, sub)
+	assert.LessOrEqual(t, len(got), 2)
+	assert.ErrorIs(t, sub.Err(), subscription.ErrSlowConsumer)
+	assert.Equal(t, uint64(1), svc.Dropped())
+
+	publish(t, svc, 2, 5)
 	select {
-	case msg := <-ch1:
-		assert.Equal(t, comment, msg)
+	case event := <-other.C():
+		assert.Equal(t, int64(5), event.CommentID)
 	case <-time.After(time.Second):
-		t.Error("timeout waiting for ch1")
+		t.Fatal("timeout: other subscribers must keep receiving")
+	}
+}
+
+func TestSlowConsumer_BlockWaitsForReader(t *testing.T) {
+	svc := newService(t, subscription.Options{Buffer: 1, Policy: subscription.PolicyBlock, BlockTimeout: 5 * time.Second})
+	sub, cancel := subscribe(t, svc, 1)
+
+	var got []int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for event := range sub.C() {
+			got = append(got, event.CommentID)
+			if len(got) == 5 {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	publish(t, svc, 1, 1, 2, 3, 4, 5)
+	wg.Wait()
+	cancel()
+
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, got)
+	assert.Zero(t, svc.Dropped())
+	assert.Zero(t, svc.DroppedComments())
+}
+
+func TestSlowConsumer_BlockTimesOut(t *testing.T) {
+	svc := newService(t, subscription.Options{Buffer: 1, Policy: subscription.PolicyBlock, BlockTimeout: 20 * time.Millisecond})
+	sub, _ := subscribe(t, svc, 1)
+
+	start := time.Now()
+	publish(t, svc, 1, 1, 2, 3)
+
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.LessOrEqual(t, len(drain(t, sub)), 2)
+	assert.ErrorIs(t, sub.Err(), subscription.ErrSlowConsumer)
+}
+
+func TestConcurrentSubscribePublishUnsubscribe(t *testing.T) {
+	for _, policy := range []subscription.Policy{subscription.PolicyDropOldest, subscription.PolicyDisconnect, subscription.PolicyBlock} {
+		t.Run(string(policy), func(t *testing.T) {
+			svc := newService(t, subscription.Options{Buffer: 2, Policy: policy, BlockTimeout: time.Millisecond})
+
+			var wg sync.WaitGroup
+			for i := range 20 {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					sub, err := svc.Subscribe(ctx, int64(i%3))
+					if err != nil {
+						assert.ErrorIs(t, err, subscription.ErrClosed)
+						return
+					}
+					for range i % 4 {
+						<-sub.C()
+					}
+					cancel()
+					for range sub.C() {
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for _, id := range []int64{int64(i), int64(i + 100), int64(i + 200)} {
+						assert.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(&model.Comment{ID: id, PostID: int64(i % 3)})))
+					}
+				}()
+			}
+			// Subscribers waiting for comments that were published before
+			// they subscribed are ended by Close.
+			time.AfterFunc(100*time.Millisecond, svc.Close)
+			wg.Wait()
+			assert.Empty(t, svc.SubscriberCounts())
+		})
+	}
+}
+
+func FuzzSubscriptionInterleavings(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5})
+	f.Add([]byte{1, 0, 0, 2, 2, 2, 2, 1, 5, 3, 4})
+	f.Add([]byte{2, 0, 0, 0, 2, 2, 2, 2, 2, 3, 3, 3})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		if len(ops) == 0 || len(ops) > 64 {
+			return
+		}
+		policies := []subscription.Policy{subscription.PolicyDropOldest, subscription.PolicyDisconnect, subscription.PolicyBlock}
+		svc := newService(t, subscription.Options{Buffer: 1 + int(ops[0])%3, Policy: policies[int(ops[0])%3], BlockTimeout: time.Millisecond})
+
+		type active struct {
+			sub    *subscription.Subscription
+			cancel context.CancelFunc
+		}
+		var subs []active
+		for i, op := range ops[1:] {
+			postID := int64(op % 2)
+			switch op % 6 {
+			case 0, 1:
+				sub, cancel := subscribe(t, svc, postID)
+				subs = append(subs, active{sub, cancel})
+			case 2, 3:
+				publish(t, svc, postID, int64(i))
+			case 4:
+				if len(subs) > 0 {
+					subs[int(op)%len(subs)].cancel()
+				}
+			case 5:
+				if len(subs) > 0 {
+					select {
+					case <-subs[int(op)%len(subs)].sub.C():
+					case <-time.After(time.Millisecond):
+					}
+				}
+			}
+		}
+
+		svc.Close()
+		for _, s := range subs {
+			drain(t, s.sub)
+			s.cancel()
+			if err := s.sub.Err(); err != nil {
+				assert.ErrorIs(t, err, subscription.ErrSlowConsumer)
+			}
+		}
+		assert.Empty(t, svc.SubscriberCounts())
+	})
+}
+
+// store is a comment storage for resumed subscriptions. add stores comments
+// and publishes them like CreateComment does.
+type store struct {
+	mu       sync.Mutex
+	comments []*model.Comment
+	// loading, if set, is called before every load.
+	loading func()
+}
+
+func (s *store) add(t testing.TB, svc *subscription.SubscriptionService, postID int64, ids ...int64) {
+	for _, id := range ids {
+		comment := &model.Comment{ID: id, PostID: postID}
+		s.mu.Lock()
+		s.comments = append(s.comments, comment)
+		s.mu.Unlock()
+		require.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(comment)))
+	}
+}
+
+func (s *store) load(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+	if s.loading != nil {
+		s.loading()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var page []*model.Comment
+	for _, comment := range s.comments {
+		if comment.PostID == postID && comment.ID > afterID && int64(len(page)) < limit {
+			page = append(page, comment)
+		}
+	}
+	return page, nil
+}
+
+func ids(from, to int64) []int64 {
+	var ids []int64
+	for id := from; id <= to; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestSubscribeSince_ReplaysThenContinuesLive(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	st := &store{}
+	st.add(t, svc, 1, ids(1, 250)...)
+	st.add(t, svc, 2, 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := svc.SubscribeSince(ctx, 1, 100, st.load)
+	require.NoError(t, err)
+
+	var got []int64
+	for len(got) < 151 {
+		select {
+		case event := <-sub.C():
+			got = append(got, event.CommentID)
+			if event.CommentID == 250 {
+				st.add(t, svc, 1, 251)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout: got %d comments", len(got))
+		}
+	}
+	cancel()
+
+	assert.Equal(t, ids(101, 251), got)
+	assert.Empty(t, drain(t, sub))
+	assert.NoError(t, sub.Err())
+}
+
+func TestSubscribeSince_SkipsReplayedLiveComments(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	st := &store{}
+	st.add(t, svc, 1, 1, 2, 3)
+
+	// Comments 4 and 5 are stored and published after the subscriber is
+	// registered but before the replay reads them, so they arrive twice.
+	var once sync.Once
+	st.loading = func() { once.Do(func() { st.add(t, svc, 1, 4, 5) }) }
+	sub, cancel := subscribeSince(t, svc, 1, 2, st)
+
+	got := receive(t, sub, 3)
+	st.add(t, svc, 1, 6)
+	got = append(got, receive(t, sub, 1)...)
+	cancel()
+
+	assert.Equal(t, []int64{3, 4, 5, 6}, got)
+	assert.Empty(t, drain(t, sub))
+}
+
+func TestSubscribeSince_ReloadsCommentsOverflowingDuringReplay(t *testing.T) {
+	svc := newService(t, subscription.Options{Buffer: 1, Policy: subscription.PolicyDisconnect})
+	st := &store{}
+	st.add(t, svc, 1, 1, 2)
+
+	// Comments 3 to 20 are published after the replay has read the stored
+	// ones and overflow the queue before the subscription goes live.
+	var once sync.Once
+	load := func(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+		page, err := st.load(ctx, postID, afterID, limit)
+		once.Do(func() { st.add(t, svc, 1, ids(3, 20)...) })
+		return page, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := svc.SubscribeSince(ctx, 1, 0, load)
+	require.NoError(t, err)
+
+	got := receive(t, sub, 20)
+	st.add(t, svc, 1, 21)
+	got = append(got, receive(t, sub, 1)...)
+	cancel()
+
+	assert.Equal(t, ids(1, 21), got)
+	assert.NoError(t, sub.Err())
+	assert.Zero(t, svc.Dropped())
+	assert.Zero(t, svc.DroppedComments())
+}
+
+func TestSubscribeSince_ReplayFailureEndsSubscription(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	load := func(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+		return nil, errors.New("connection refused")
+	}
+	sub, err := svc.SubscribeSince(context.Background(), 1, 0, load)
+	require.NoError(t, err)
+
+	assert.Empty(t, drain(t, sub))
+	assert.ErrorIs(t, sub.Err(), subscription.ErrReplayFailed)
+	assert.Empty(t, svc.SubscriberCounts())
+}
+
+func subscribeSince(t testing.TB, svc *subscription.SubscriptionService, postID, since int64, st *store) (*subscription.Subscription, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sub, err := svc.SubscribeSince(ctx, postID, since, st.load)
+	require.NoError(t, err)
+	return sub, cancel
+}
+
+func receive(t testing.TB, sub *subscription.Subscription, n int) []int64 {
+	var got []int64
+	for len(got) < n {
+		select {
+		case event, ok := <-sub.C():
+			require.True(t, ok, "subscription closed after %v", got)
+			got = append(got, event.CommentID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout: got %v", got)
+		}
+	}
+	return got
+}
+
+func TestEventsReachSubscribersOfTheirTopics(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	comments, _ := subscribe(t, svc, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	activity, err := svc.SubscribePostActivity(ctx, 1)
+	require.NoError(t, err)
+	otherPost, err := svc.SubscribePostActivity(ctx, 2)
+	require.NoError(t, err)
+	posts, err := svc.SubscribePostsCreated(ctx)
+	require.NoError(t, err)
+
+	post := &model.Post{ID: 1, CommentsAllowed: false}
+	comment := &model.Comment{ID: 10, PostID: 1}
+	events := []*subscription.Event{
+		subscription.PostCreated(post),
+		subscription.CommentAdded(comment),
+		subscription.CommentEdited(comment),
+		subscription.CommentDeleted(1, 10),
+		subscription.CommentsToggled(post),
+		subscription.PostUpdated(post),
+	}
+	for _, event := range events {
+		require.NoError(t, svc.Publish(context.Background(), event))
+	}
+	assert.Equal(t, map[int64]int{1: 1}, svc.SubscriberCounts(), "only commentAdded subscribers are counted")
+
+	kinds := func(sub *subscription.Subscription, n int) []subscription.EventKind {
+		var kinds []subscription.EventKind
+		for len(kinds) < n {
+			select {
+			case event := <-sub.C():
+				kinds = append(kinds, event.Kind)
+			case <-time.After(time.Second):
+				t.Fatalf("timeout: got %v", kinds)
+			}
+		}
+		return kinds
+	}
+	assert.Equal(t, []subscription.EventKind{
+		subscription.EventCommentAdded,
+		subscription.EventCommentEdited,
+		subscription.EventCommentDeleted,
+		subscription.EventCommentsToggled,
+		subscription.EventPostUpdated,
+	}, kinds(activity, 5))
+	assert.Equal(t, []subscription.EventKind{subscription.EventPostCreated}, kinds(posts, 1))
+	select {
+	case event := <-otherPost.C():
+		t.Fatalf("post 2 subscriber received %s of post 1", event.Kind)
+	case <-time.After(20 * time.Millisecond):
 	}
 
 	select {
-	case msg := <-ch2:
-		assert.Equal(t, comment, msg)
+	case event := <-comments.C():
+		assert.Equal(t, subscription.EventCommentAdded, event.Kind)
 	case <-time.After(time.Second):
-		t.Error("timeout waiting for ch2")
+		t.Fatal("timeout: added comment not delivered")
+	}
+	select {
+	case event := <-comments.C():
+		t.Fatalf("commentAdded subscriber received %s", event.Kind)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRepliesAndAuthorSubscriptions(t *testing.T) {
+	svc := newService(t, subscription.Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	author := uuid.New()
+
+	thread, err := svc.SubscribeReplies(ctx, 1)
+	require.NoError(t, err)
+	subthread, err := svc.SubscribeReplies(ctx, 2)
+	require.NoError(t, err)
+	byAuthor, err := svc.SubscribeAuthor(ctx, author)
+	require.NoError(t, err)
+
+	// 1 <- 2 <- 3 is a thread; 4 is a top-level comment by author.
+	publishComment := func(id int64, author uuid.UUID, ancestors ...int64) {
+		comment := &model.Comment{ID: id, PostID: 1, AuthorID: author}
+		require.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(comment, ancestors...)))
+	}
+	publishComment(2, uuid.New(), 1)
+	publishComment(3, author, 2, 1)
+	publishComment(4, author)
+	require.NoError(t, svc.Publish(context.Background(), subscription.CommentEdited(&model.Comment{ID: 3, PostID: 1, AuthorID: author})))
+
+	assert.Equal(t, []int64{2, 3}, receive(t, thread, 2))
+	assert.Equal(t, []int64{3}, receive(t, subthread, 1))
+	assert.Equal(t, []int64{3, 4}, receive(t, byAuthor, 2))
+	for _, sub := range []*subscription.Subscription{thread, subthread, byAuthor} {
+		select {
+		case event := <-sub.C():
+			t.Fatalf("unexpected %s of comment %d", event.Kind, event.CommentID)
+		case <-time.After(20 * time.Millisecond):
+		}
 	}
+	assert.Empty(t, svc.SubscriberCounts(), "thread and author subscribers are not counted per post")
 }
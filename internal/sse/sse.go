@@ -0,0 +1,154 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/middleware"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/ratelimit"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"go.uber.org/zap"
+)
+
+// PostGetter checks that the post being watched exists.
+type PostGetter interface {
+	GetPost(ctx context.Context, id int64) (*model.Post, error)
+}
+
+// PostEvents streams the comments added to a post as Server-Sent Events,
+// for clients that can't keep a websocket open. It is served on a pattern
+// with an {id} wildcard. Every event carries the comment ID, so a client
+// that reconnects with Last-Event-ID first gets the comments it missed.
+type PostEvents struct {
+	Subscriptions *subscription.SubscriptionService
+	Posts         PostGetter
+	// Load replays missed comments for resumed streams.
+	Load subscription.LoadFunc
+	// KeepAlive is the interval of the comments sent while no event is,
+	// so that proxies don't close an idle stream.
+	KeepAlive time.Duration
+	// Limiter, if set, applies Rule to each client IP before a stream is
+	// opened. Streams are keyed like the commentAdded subscription, so both
+	// share one budget.
+	Limiter ratelimit.Limiter
+	Rule    ratelimit.Rule
+}
+
+// rateLimitKey is the key of the commentAdded subscription's IP bucket.
+func rateLimitKey(ip string) string {
+	return "commentAdded:ip:" + ip
+}
+
+type streamError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+func (h *PostEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := log.FromContext(ctx).Named(log.PackageGraph)
+
+	postID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || postID < 0 {
+		http.Error(w, "invalid post ID", http.StatusBadRequest)
+		return
+	}
+	var since *int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		since = &id
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	if h.Limiter != nil {
+		if ip := middleware.ClientIPFromContext(ctx); ip != "" {
+			res, err := h.Limiter.Allow(ctx, h.Rule, rateLimitKey(ip))
+			if err != nil {
+				logger.Warn("Rate limiter unavailable, allowing request", zap.Error(err), zap.String("ip", ip))
+			} else if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				http.Error(w, "rate limit exceeded, retry later", http.StatusTooManyRequests)
+				return
+			}
+		}
+	}
+
+	if _, err := h.Posts.GetPost(ctx, postID); err != nil {
+		if errors.Is(err, errs.ErrPostNotFound) {
+			http.Error(w, errs.ErrPostNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to check post for event stream", zap.Error(err), zap.Int64("post_id", postID))
+		http.Error(w, errs.ErrInternalServerError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var sub *subscription.Subscription
+	if since != nil {
+		sub, err = h.Subscriptions.SubscribeSince(ctx, postID, *since, h.Load)
+	} else {
+		sub, err = h.Subscriptions.Subscribe(ctx, postID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Tells nginx not to buffer the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ":\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(h.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.C():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					data, _ := json.Marshal(streamError{Message: err.Error(), Code: "SUBSCRIPTION_ENDED"})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+					flusher.Flush()
+				}
+				return
+			}
+			data, err := json.Marshal(event.Comment)
+			if err != nil {
+				logger.Error("Failed to encode comment event", zap.Error(err), zap.Int64("comment_id", event.CommentID))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: comment\ndata: %s\n\n", event.CommentID, data); err != nil {
+				return
+			}
+			keepAlive.Reset(h.KeepAlive)
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,197 @@
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/middleware"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/ratelimit"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/service/subscription"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/sse"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type posts map[int64]*model.Post
+
+func (p posts) GetPost(_ context.Context, id int64) (*model.Post, error) {
+	post, ok := p[id]
+	if !ok {
+		return nil, errs.ErrPostNotFound
+	}
+	return post, nil
+}
+
+func newServer(t *testing.T, load subscription.LoadFunc, keepAlive time.Duration) (*httptest.Server, *subscription.SubscriptionService) {
+	svc, err := subscription.NewSubscriptionService(context.Background(), subscription.NewMemoryBroker(), subscription.Options{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /events/posts/{id}", &sse.PostEvents{
+		Subscriptions: svc,
+		Posts:         posts{1: {ID: 1}},
+		Load:          load,
+		KeepAlive:     keepAlive,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, svc
+}
+
+func get(t *testing.T, url, lastEventID string) *http.Response {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readMessage returns the next message of the stream without its trailing
+// blank line.
+func readMessage(t *testing.T, r *bufio.Reader) string {
+	done := make(chan string, 1)
+	go func() {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				done <- strings.Join(lines, "\n")
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				done <- strings.Join(lines, "\n")
+				return
+			}
+			lines = append(lines, line)
+		}
+	}()
+	select {
+	case msg := <-done:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for stream message")
+		return ""
+	}
+}
+
+func publish(t *testing.T, svc *subscription.SubscriptionService, postID, id int64) {
+	require.NoError(t, svc.Publish(context.Background(), subscription.CommentAdded(&model.Comment{
+		ID: id, PostID: postID, AuthorID: uuid.New(), Content: "hi",
+	})))
+}
+
+func TestPostEventsStreamsComments(t *testing.T) {
+	srv, svc := newServer(t, nil, time.Minute)
+
+	resp := get(t, srv.URL+"/events/posts/1", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	stream := bufio.NewReader(resp.Body)
+	require.Equal(t, ":", readMessage(t, stream))
+
+	publish(t, svc, 2, 6)
+	publish(t, svc, 1, 7)
+	msg := readMessage(t, stream)
+	assert.True(t, strings.HasPrefix(msg, "id: 7\nevent: comment\ndata: {"), msg)
+	assert.Contains(t, msg, `"content":"hi"`)
+}
+
+func TestPostEventsResumesFromLastEventID(t *testing.T) {
+	var after int64 = -1
+	load := func(_ context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+		if afterID >= 5 {
+			return nil, nil
+		}
+		after = afterID
+		return []*model.Comment{{ID: 4, PostID: postID}, {ID: 5, PostID: postID}}, nil
+	}
+	srv, svc := newServer(t, load, time.Minute)
+
+	resp := get(t, srv.URL+"/events/posts/1", "3")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stream := bufio.NewReader(resp.Body)
+	require.Equal(t, ":", readMessage(t, stream))
+	assert.Contains(t, readMessage(t, stream), "id: 4\n")
+	assert.Contains(t, readMessage(t, stream), "id: 5\n")
+	assert.Equal(t, int64(3), after)
+
+	publish(t, svc, 1, 6)
+	assert.Contains(t, readMessage(t, stream), "id: 6\n")
+}
+
+func TestPostEventsSendsKeepAlive(t *testing.T) {
+	srv, _ := newServer(t, nil, 20*time.Millisecond)
+
+	resp := get(t, srv.URL+"/events/posts/1", "")
+	stream := bufio.NewReader(resp.Body)
+	require.Equal(t, ":", readMessage(t, stream))
+	assert.Equal(t, ": keep-alive", readMessage(t, stream))
+}
+
+func TestPostEventsRejectsBadRequests(t *testing.T) {
+	srv, _ := newServer(t, nil, time.Minute)
+
+	tests := []struct {
+		name        string
+		path        string
+		lastEventID string
+		status      int
+	}{
+		{name: "invalid post ID", path: "/events/posts/abc", status: http.StatusBadRequest},
+		{name: "invalid Last-Event-ID", path: "/events/posts/1", lastEventID: "x", status: http.StatusBadRequest},
+		{name: "unknown post", path: "/events/posts/2", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(t, srv.URL+tt.path, tt.lastEventID)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestPostEventsSharesCommentAddedRateLimit(t *testing.T) {
+	svc, err := subscription.NewSubscriptionService(context.Background(), subscription.NewMemoryBroker(), subscription.Options{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+	limiter := ratelimit.NewMemoryLimiter()
+	rule := ratelimit.Rule{Rate: 2, Period: time.Minute}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /events/posts/{id}", middleware.ClientIP(false)(&sse.PostEvents{
+		Subscriptions: svc,
+		Posts:         posts{1: {ID: 1}},
+		KeepAlive:     time.Minute,
+		Limiter:       limiter,
+		Rule:          rule,
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// A commentAdded subscription from the same client spends the first token.
+	res, err := limiter.Allow(context.Background(), rule, "commentAdded:ip:127.0.0.1")
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+
+	resp := get(t, srv.URL+"/events/posts/1", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = get(t, srv.URL+"/events/posts/1", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+}
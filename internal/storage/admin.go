@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+)
+
+// Admin is implemented by backends that support the maintenance commands of
+// the main binary. It works on raw rows: IDs and timestamps are kept as they
+// are and the comments-allowed flag is not enforced.
+type Admin interface {
+	// Dump returns every post and comment, ordered by ID.
+	Dump(ctx context.Context) (*Snapshot, error)
+	// Restore inserts the snapshot as one unit, keeping its IDs. It fails
+	// without changes if any ID is already taken.
+	Restore(ctx context.Context, snapshot *Snapshot) error
+	// PurgePost deletes a post with all its comments and returns how many
+	// comments were removed.
+	PurgePost(ctx context.Context, postID int64) (int64, error)
+	// Recount moves the ID counters past the stored rows and returns the
+	// number of rows, e.g. after rows were inserted with explicit IDs.
+	Recount(ctx context.Context) (Counts, error)
+}
+
+// Snapshot is the full content of a backend, as written by export.
+type Snapshot struct {
+	Posts    []*model.Post    `json:"posts"`
+	Comments []*model.Comment `json:"comments"`
+}
+
+type Counts struct {
+	Posts    int64 `json:"posts"`
+	Comments int64 `json:"comments"`
+}
@@ -1 +1,49 @@
 package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// RedisCache is a graphql.Cache shared by all server instances, used to keep
+// automatic persisted queries warm across replicas and restarts.
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	log    *zap.Logger
+}
+
+var _ graphql.Cache[string] = &RedisCache{}
+
+func NewRedisCache(client *redis.Client, prefix string, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+		log:    log.GetLogger(),
+	}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) (string, bool) {
+	value, err := c.client.Get(ctx, c.prefix+key).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.log.Warn("Failed to read from redis cache", zap.Error(err), zap.String("key", key))
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (c *RedisCache) Add(ctx context.Context, key string, value string) {
+	if err := c.client.Set(ctx, c.prefix+key, value, c.ttl).Err(); err != nil {
+		c.log.Warn("Failed to write to redis cache", zap.Error(err), zap.String("key", key))
+	}
+}
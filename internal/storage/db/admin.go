@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+var _ storage.Admin = (*StorageDB)(nil)
+
+func (r *StorageDB) Dump(ctx context.Context) (*storage.Snapshot, error) {
+	// Repeatable read gives posts and comments from the same point in time.
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	snapshot := &storage.Snapshot{}
+	rows, err := tx.Query(ctx, `SELECT post_id, author_id, title, content, allow_comments, created_at
+		FROM posts ORDER BY post_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump posts: %w", err)
+	}
+	snapshot.Posts, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (*model.Post, error) {
+		post := &model.Post{}
+		err := row.Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
+		return post, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump posts: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `SELECT comment_id, author_id, post_id, parent_id, content, created_at
+		FROM comments ORDER BY comment_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump comments: %w", err)
+	}
+	snapshot.Comments, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (*model.Comment, error) {
+		comment := &model.Comment{}
+		err := row.Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
+		return comment, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump comments: %w", err)
+	}
+	return snapshot, tx.Commit(ctx)
+}
+
+func (r *StorageDB) Restore(ctx context.Context, snapshot *storage.Snapshot) error {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"posts"},
+		[]string{"post_id", "author_id", "title", "content", "allow_comments", "created_at"},
+		pgx.CopyFromSlice(len(snapshot.Posts), func(i int) ([]any, error) {
+			p := snapshot.Posts[i]
+			return []any{p.ID, p.AuthorID, p.Title, p.Content, p.CommentsAllowed, p.CreatedAt}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to restore posts: %w", err)
+	}
+	// Foreign keys are checked at the end of the statement, so comments may
+	// come in any order.
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"comments"},
+		[]string{"comment_id", "author_id", "post_id", "parent_id", "content", "created_at"},
+		pgx.CopyFromSlice(len(snapshot.Comments), func(i int) ([]any, error) {
+			c := snapshot.Comments[i]
+			return []any{c.ID, c.AuthorID, c.PostID, c.ParentID, c.Content, c.CreatedAt}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to restore comments: %w", err)
+	}
+	if _, err := recount(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	logger.Info("Snapshot restored", zap.Int("posts", len(snapshot.Posts)), zap.Int("comments", len(snapshot.Comments)))
+	return nil
+}
+
+func (r *StorageDB) PurgePost(ctx context.Context, postID int64) (int64, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM idempotency_keys
+		WHERE (scope LIKE $1 AND resource_id = $2)
+		   OR (scope LIKE $3 AND resource_id IN (SELECT comment_id FROM comments WHERE post_id = $2))`,
+		idempotency.ScopePost+":%", postID, idempotency.ScopeComment+":%")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete idempotency keys: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM comments WHERE post_id = $1`, postID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete comments: %w", err)
+	}
+	comments := tag.RowsAffected()
+	tag, err = tx.Exec(ctx, `DELETE FROM posts WHERE post_id = $1`, postID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete post: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, errs.ErrPostNotFound
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	logger.Info("Post purged", zap.Int64("post_id", postID), zap.Int64("comments", comments))
+	return comments, nil
+}
+
+func (r *StorageDB) Recount(ctx context.Context) (storage.Counts, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return storage.Counts{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	counts, err := recount(ctx, tx)
+	if err != nil {
+		return storage.Counts{}, err
+	}
+	return counts, tx.Commit(ctx)
+}
+
+// recount moves the ID sequences past the highest stored ID. Sequences never
+// go back, so IDs of purged rows are not handed out again.
+func recount(ctx context.Context, tx pgx.Tx) (storage.Counts, error) {
+	var counts storage.Counts
+	for _, t := range []struct {
+		table, column, sequence string
+		count                   *int64
+	}{
+		{"posts", "post_id", "posts_post_id_seq", &counts.Posts},
+		{"comments", "comment_id", "comments_comment_id_seq", &counts.Comments},
+	} {
+		query := fmt.Sprintf(`WITH last AS (
+				SELECT GREATEST(
+					COALESCE((SELECT MAX(%[2]s) FROM %[1]s), 0),
+					(SELECT CASE WHEN is_called THEN last_value ELSE last_value - 1 END FROM %[3]s)
+				) AS id
+			)
+			SELECT setval('%[3]s', GREATEST(id, 1), id > 0), (SELECT COUNT(*) FROM %[1]s) FROM last`,
+			t.table, t.column, t.sequence)
+		var last int64
+		if err := tx.QueryRow(ctx, query).Scan(&last, t.count); err != nil {
+			return storage.Counts{}, fmt.Errorf("failed to recount %s: %w", t.table, err)
+		}
+	}
+	return counts, nil
+}
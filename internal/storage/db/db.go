@@ -2,10 +2,14 @@ package db
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/log"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,19 +17,77 @@ import (
 )
 
 type StorageDB struct {
-	db  *pgxpool.Pool
-	log *zap.Logger
+	db             *pgxpool.Pool
+	idempotencyTTL time.Duration
+	outboxReady    chan struct{}
 }
 
-func NewStorageDB(db *pgxpool.Pool) *StorageDB {
+func init() {
+	storage.Register(storage.StorageTypeDB, func(ctx context.Context, cfg *config.Config) (storage.Storage, error) {
+		pool, err := cfg.ConnectDatabase(ctx)
+		if err != nil {
+			return nil, err
+		}
+		logger := log.FromContext(ctx).Named(log.PackageStorage)
+		logger.Info("Database connection established",
+			zap.String("host", cfg.Database.Host), zap.String("port", cfg.Database.Port), zap.String("name", cfg.Database.Name))
+		if err := prepareSchema(ctx, pool, cfg.Database.AutoMigrate, logger); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		return NewStorageDB(pool, cfg.Idempotency.TTL), nil
+	})
+}
+
+func NewStorageDB(db *pgxpool.Pool, idempotencyTTL time.Duration) *StorageDB {
 	return &StorageDB{
-		db:  db,
-		log: log.GetLogger(),
+		db:             db,
+		idempotencyTTL: idempotencyTTL,
+		outboxReady:    make(chan struct{}, 1),
 	}
 }
 
 func (r *StorageDB) CreatePost(ctx context.Context, newPost *model.NewPost) (*model.Post, error) {
-	r.log.Info("Creating new post", zap.String("author_id", newPost.AuthorID.String()))
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Info("Creating new post", zap.String("author_id", newPost.AuthorID.String()))
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	scope := idempotency.Scope(idempotency.ScopePost, newPost.AuthorID)
+	if newPost.IdempotencyKey != nil {
+		requestHash := idempotency.Fingerprint(newPost.Title, newPost.Content, newPost.CommentsAllowed)
+		postID, claimed, err := r.claimIdempotencyKey(ctx, tx, scope, *newPost.IdempotencyKey, requestHash)
+		if errors.Is(err, errs.ErrIdempotencyKeyReused) {
+			logger.Warn("Idempotency key reused for a different post", zap.String("author_id", newPost.AuthorID.String()))
+			return nil, err
+		}
+		if err != nil {
+			logger.Error("Failed to claim idempotency key", zap.Error(err), zap.String("author_id", newPost.AuthorID.String()))
+			return nil, err
+		}
+		if !claimed {
+			post := &model.Post{}
+			err = tx.QueryRow(ctx, `SELECT post_id, author_id, title, content, allow_comments, created_at
+				FROM posts WHERE post_id = $1`, postID).Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
+			if err != nil {
+				logger.Error("Failed to fetch post for idempotency key", zap.Error(err), zap.Int64("post_id", postID))
+				return nil, err
+			}
+			if err = tx.Commit(ctx); err != nil {
+				logger.Error("Failed to commit transaction", zap.Error(err))
+				return nil, err
+			}
+			idempotency.MarkReplayed(ctx)
+			logger.Info("Post creation replayed", zap.Int64("post_id", post.ID), zap.String("author_id", post.AuthorID.String()))
+			return post, nil
+		}
+	}
+
 	post := &model.Post{
 		AuthorID:        newPost.AuthorID,
 		Title:           newPost.Title,
@@ -37,45 +99,99 @@ func (r *StorageDB) CreatePost(ctx context.Context, newPost *model.NewPost) (*mo
 	query := `INSERT INTO posts (author_id, title, content, allow_comments, created_at)
 			  VALUES ($1, $2, $3, $4, $5)
 			  RETURNING post_id`
-	err := r.db.QueryRow(ctx, query, post.AuthorID, post.Title, post.Content, post.CommentsAllowed, post.CreatedAt).Scan(&post.ID)
+	err = tx.QueryRow(ctx, query, post.AuthorID, post.Title, post.Content, post.CommentsAllowed, post.CreatedAt).Scan(&post.ID)
 	if err != nil {
-		r.log.Error("Failed to create post", zap.Error(err), zap.String("author_id", post.AuthorID.String()))
+		logger.Error("Failed to create post", zap.Error(err), zap.String("author_id", post.AuthorID.String()))
+		return nil, err
+	}
+
+	if newPost.IdempotencyKey != nil {
+		if err = r.completeIdempotencyKey(ctx, tx, scope, *newPost.IdempotencyKey, post.ID); err != nil {
+			logger.Error("Failed to store idempotency key", zap.Error(err), zap.Int64("post_id", post.ID))
+			return nil, err
+		}
+	}
+
+	if err = r.addEvent(ctx, tx, storage.PostEvent(storage.EventPostCreated, post)); err != nil {
+		logger.Error("Failed to store event", zap.Error(err), zap.Int64("post_id", post.ID))
 		return nil, err
 	}
 
-	r.log.Info("Post created", zap.Int64("post_id", post.ID), zap.String("author_id", post.AuthorID.String()))
+	if err = tx.Commit(ctx); err != nil {
+		logger.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+	r.eventsStored()
+
+	logger.Info("Post created", zap.Int64("post_id", post.ID), zap.String("author_id", post.AuthorID.String()))
 
 	return post, nil
 }
 
 func (r *StorageDB) CreateComment(ctx context.Context, newComment *model.NewComment) (*model.Comment, error) {
-	r.log.Info("Creating new comment", zap.String("author_id", newComment.AuthorID.String()), zap.Int64("post_id", newComment.PostID))
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Info("Creating new comment", zap.String("author_id", newComment.AuthorID.String()), zap.Int64("post_id", newComment.PostID))
 
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		r.log.Error("Failed to begin transaction", zap.Error(err))
+		logger.Error("Failed to begin transaction", zap.Error(err))
 		return nil, err
 	}
 	defer tx.Rollback(ctx)
 
+	scope := idempotency.Scope(idempotency.ScopeComment, newComment.AuthorID)
+	if newComment.IdempotencyKey != nil {
+		requestHash := idempotency.Fingerprint(newComment.PostID, newComment.ParentID, newComment.Content)
+		commentID, claimed, err := r.claimIdempotencyKey(ctx, tx, scope, *newComment.IdempotencyKey, requestHash)
+		if errors.Is(err, errs.ErrIdempotencyKeyReused) {
+			logger.Warn("Idempotency key reused for a different comment", zap.String("author_id", newComment.AuthorID.String()))
+			return nil, err
+		}
+		if err != nil {
+			logger.Error("Failed to claim idempotency key", zap.Error(err), zap.String("author_id", newComment.AuthorID.String()))
+			return nil, err
+		}
+		if !claimed {
+			comment := &model.Comment{}
+			err = tx.QueryRow(ctx, `SELECT comment_id, author_id, post_id, parent_id, content, created_at
+				FROM comments WHERE comment_id = $1`, commentID).Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
+			if err != nil {
+				logger.Error("Failed to fetch comment for idempotency key", zap.Error(err), zap.Int64("comment_id", commentID))
+				return nil, err
+			}
+			if err = tx.Commit(ctx); err != nil {
+				logger.Error("Failed to commit transaction", zap.Error(err))
+				return nil, err
+			}
+			idempotency.MarkReplayed(ctx)
+			logger.Info("Comment creation replayed", zap.Int64("comment_id", comment.ID))
+			return comment, nil
+		}
+	}
+
 	var commentsAllowed bool
 	err = tx.QueryRow(ctx, `SELECT allow_comments FROM posts WHERE post_id = $1`, newComment.PostID).Scan(&commentsAllowed)
 	if err != nil {
-		r.log.Error("Failed to check if comments are allowed", zap.Error(err))
+		logger.Error("Failed to check if comments are allowed", zap.Error(err))
 		return nil, err
 	}
 	if !commentsAllowed {
-		r.log.Warn("Comments are not allowed", zap.Int64("post_id", newComment.PostID))
+		logger.Warn("Comments are not allowed", zap.Int64("post_id", newComment.PostID))
 		return nil, errs.ErrCommentsNotAllowed
 	}
 
+	var ancestors []int64
 	if newComment.ParentID != nil {
 		var parentExists bool
 		err = tx.QueryRow(ctx, `
 			SELECT EXISTS(SELECT 1 FROM comments WHERE comment_id = $1 AND post_id = $2)
 		`, *newComment.ParentID, newComment.PostID).Scan(&parentExists)
 		if err != nil || !parentExists {
-			r.log.Warn("Parent comment doesn't exist", zap.Int64("parent_id", *newComment.ParentID))
+			logger.Warn("Parent comment doesn't exist", zap.Int64("parent_id", *newComment.ParentID))
+			return nil, err
+		}
+		if ancestors, err = commentPath(ctx, tx, *newComment.ParentID); err != nil {
+			logger.Error("Failed to fetch parent comment path", zap.Error(err), zap.Int64("parent_id", *newComment.ParentID))
 			return nil, err
 		}
 	}
@@ -95,44 +211,201 @@ func (r *StorageDB) CreateComment(ctx context.Context, newComment *model.NewComm
 	`, comment.AuthorID, comment.PostID, comment.ParentID, comment.Content, comment.CreatedAt).Scan(&comment.ID, &comment.CreatedAt)
 
 	if err != nil {
-		r.log.Error("Failed to create comment", zap.Error(err))
+		logger.Error("Failed to create comment", zap.Error(err))
+		return nil, err
+	}
+
+	if newComment.IdempotencyKey != nil {
+		if err = r.completeIdempotencyKey(ctx, tx, scope, *newComment.IdempotencyKey, comment.ID); err != nil {
+			logger.Error("Failed to store idempotency key", zap.Error(err), zap.Int64("comment_id", comment.ID))
+			return nil, err
+		}
+	}
+
+	if err = r.addEvent(ctx, tx, storage.CommentEvent(storage.EventCommentAdded, comment, ancestors...)); err != nil {
+		logger.Error("Failed to store event", zap.Error(err), zap.Int64("comment_id", comment.ID))
 		return nil, err
 	}
 
 	if err = tx.Commit(ctx); err != nil {
-		r.log.Error("Failed to commit transaction", zap.Error(err))
+		logger.Error("Failed to commit transaction", zap.Error(err))
 		return nil, err
 	}
+	r.eventsStored()
 
-	r.log.Info("Comment created", zap.Int64("comment_id", comment.ID))
+	logger.Info("Comment created", zap.Int64("comment_id", comment.ID))
 	return comment, nil
 }
 
 func (r *StorageDB) AllowComments(ctx context.Context, authorID string, postID int64, allowed bool) (*model.Post, error) {
-	r.log.Info("Updating comments allowed for post", zap.Int64("post_id", postID), zap.String("author_id", authorID), zap.Bool("allowed", allowed))
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Info("Updating comments allowed for post", zap.Int64("post_id", postID), zap.String("author_id", authorID), zap.Bool("allowed", allowed))
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
 
 	query := `UPDATE posts SET allow_comments = $1 WHERE post_id = $2 AND author_id = $3 RETURNING post_id, author_id, title, content, allow_comments, created_at`
 	post := &model.Post{}
-	err := r.db.QueryRow(ctx, query, allowed, postID, authorID).Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
+	err = tx.QueryRow(ctx, query, allowed, postID, authorID).Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
 	if err != nil {
 		if err.Error() == "no rows in result set" {
-			r.log.Warn("Post not found or author mismatch", zap.Int64("post_id", postID), zap.String("author_id", authorID))
+			logger.Warn("Post not found or author mismatch", zap.Int64("post_id", postID), zap.String("author_id", authorID))
+			return nil, errs.ErrPostNotFound
+		}
+		logger.Error("Failed to update comments allowed", zap.Error(err), zap.Int64("post_id", postID), zap.String("author_id", authorID))
+		return nil, err
+	}
+	if err = r.addEvent(ctx, tx, storage.PostEvent(storage.EventCommentsToggled, post)); err != nil {
+		logger.Error("Failed to store event", zap.Error(err), zap.Int64("post_id", post.ID))
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		logger.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+	r.eventsStored()
+
+	logger.Info("Comments allowed updated", zap.Int64("post_id", post.ID), zap.String("author_id", post.AuthorID.String()), zap.Bool("allowed", post.CommentsAllowed))
+	return post, nil
+}
+func (r *StorageDB) UpdatePost(ctx context.Context, authorID string, postID int64, title, content *string) (*model.Post, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Info("Updating post", zap.Int64("post_id", postID), zap.String("author_id", authorID))
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE posts SET title = COALESCE($1, title), content = COALESCE($2, content)
+			  WHERE post_id = $3 AND author_id = $4
+			  RETURNING post_id, author_id, title, content, allow_comments, created_at`
+	post := &model.Post{}
+	err = tx.QueryRow(ctx, query, title, content, postID, authorID).Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Warn("Post not found or author mismatch", zap.Int64("post_id", postID), zap.String("author_id", authorID))
 			return nil, errs.ErrPostNotFound
 		}
-		r.log.Error("Failed to update comments allowed", zap.Error(err), zap.Int64("post_id", postID), zap.String("author_id", authorID))
+		logger.Error("Failed to update post", zap.Error(err), zap.Int64("post_id", postID), zap.String("author_id", authorID))
+		return nil, err
+	}
+	if err = r.addEvent(ctx, tx, storage.PostEvent(storage.EventPostUpdated, post)); err != nil {
+		logger.Error("Failed to store event", zap.Error(err), zap.Int64("post_id", post.ID))
 		return nil, err
 	}
+	if err = tx.Commit(ctx); err != nil {
+		logger.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+	r.eventsStored()
 
-	r.log.Info("Comments allowed updated", zap.Int64("post_id", post.ID), zap.String("author_id", post.AuthorID.String()), zap.Bool("allowed", post.CommentsAllowed))
+	logger.Info("Post updated", zap.Int64("post_id", post.ID), zap.String("author_id", post.AuthorID.String()))
 	return post, nil
 }
+
+func (r *StorageDB) EditComment(ctx context.Context, authorID string, commentID int64, content string) (*model.Comment, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Info("Editing comment", zap.Int64("comment_id", commentID), zap.String("author_id", authorID))
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE comments SET content = $1 WHERE comment_id = $2 AND author_id = $3
+			  RETURNING comment_id, author_id, post_id, parent_id, content, created_at`
+	comment := &model.Comment{}
+	err = tx.QueryRow(ctx, query, content, commentID, authorID).Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Warn("Comment not found or author mismatch", zap.Int64("comment_id", commentID), zap.String("author_id", authorID))
+			return nil, errs.ErrCommentNotFound
+		}
+		logger.Error("Failed to edit comment", zap.Error(err), zap.Int64("comment_id", commentID), zap.String("author_id", authorID))
+		return nil, err
+	}
+	if err = r.addEvent(ctx, tx, storage.CommentEvent(storage.EventCommentEdited, comment)); err != nil {
+		logger.Error("Failed to store event", zap.Error(err), zap.Int64("comment_id", comment.ID))
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		logger.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+	r.eventsStored()
+
+	logger.Info("Comment edited", zap.Int64("comment_id", comment.ID))
+	return comment, nil
+}
+
+// DeleteComment relies on ON DELETE CASCADE to remove the replies.
+func (r *StorageDB) DeleteComment(ctx context.Context, authorID string, commentID int64) (*model.Comment, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Info("Deleting comment", zap.Int64("comment_id", commentID), zap.String("author_id", authorID))
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		WITH RECURSIVE thread AS (
+			SELECT comment_id FROM comments WHERE comment_id = $1 AND author_id = $2
+			UNION ALL
+			SELECT c.comment_id FROM comments c JOIN thread t ON c.parent_id = t.comment_id
+		)
+		DELETE FROM idempotency_keys WHERE scope LIKE $3 AND resource_id IN (SELECT comment_id FROM thread)`,
+		commentID, authorID, idempotency.ScopeComment+":%")
+	if err != nil {
+		logger.Error("Failed to delete idempotency keys", zap.Error(err), zap.Int64("comment_id", commentID))
+		return nil, err
+	}
+
+	comment := &model.Comment{}
+	err = tx.QueryRow(ctx, `DELETE FROM comments WHERE comment_id = $1 AND author_id = $2
+		RETURNING comment_id, author_id, post_id, parent_id, content, created_at`, commentID, authorID).
+		Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Warn("Comment not found or author mismatch", zap.Int64("comment_id", commentID), zap.String("author_id", authorID))
+			return nil, errs.ErrCommentNotFound
+		}
+		logger.Error("Failed to delete comment", zap.Error(err), zap.Int64("comment_id", commentID))
+		return nil, err
+	}
+	if err = r.addEvent(ctx, tx, storage.CommentEvent(storage.EventCommentDeleted, comment)); err != nil {
+		logger.Error("Failed to store event", zap.Error(err), zap.Int64("comment_id", comment.ID))
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		logger.Error("Failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+	r.eventsStored()
+
+	logger.Info("Comment deleted", zap.Int64("comment_id", comment.ID), zap.Int64("post_id", comment.PostID))
+	return comment, nil
+}
+
 func (r *StorageDB) GetPosts(ctx context.Context) ([]*model.Post, error) {
-	r.log.Info("Fetching all posts")
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	logger.Debug("Fetching all posts")
 	query := `SELECT post_id, author_id, title, content, allow_comments, created_at
 			  FROM posts`
 	rows, err := r.db.Query(ctx, query)
 	if err != nil {
-		r.log.Error("Failed to fetch posts", zap.Error(err))
+		logger.Error("Failed to fetch posts", zap.Error(err))
 		return nil, err
 	}
 	defer rows.Close()
@@ -142,30 +415,31 @@ func (r *StorageDB) GetPosts(ctx context.Context) ([]*model.Post, error) {
 		post := &model.Post{}
 		err := rows.Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
 		if err != nil {
-			r.log.Error("Failed to scan post", zap.Error(err))
+			logger.Error("Failed to scan post", zap.Error(err))
 			return nil, err
 		}
 		posts = append(posts, post)
 	}
 
 	if err = rows.Err(); err != nil {
-		r.log.Error("Failed to fetch posts", zap.Error(err))
+		logger.Error("Failed to fetch posts", zap.Error(err))
 		return nil, err
 	}
-	r.log.Info("Posts fetched successfully", zap.Int("count", len(posts)))
+	logger.Debug("Posts fetched successfully", zap.Int("count", len(posts)))
 	return posts, nil
 }
 func (r *StorageDB) GetPost(ctx context.Context, id int64) (*model.Post, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
 	query := `SELECT post_id, author_id, title, content, allow_comments, created_at
 			  FROM posts WHERE post_id = $1`
 	post := &model.Post{}
 	err := r.db.QueryRow(ctx, query, id).Scan(&post.ID, &post.AuthorID, &post.Title, &post.Content, &post.CommentsAllowed, &post.CreatedAt)
 	if err != nil {
 		if err.Error() == "no rows in result set" {
-			r.log.Warn("Post not found", zap.Int64("post_id", id))
+			logger.Warn("Post not found", zap.Int64("post_id", id))
 			return nil, errs.ErrPostNotFound
 		}
-		r.log.Error("Failed to fetch post", zap.Error(err), zap.Int64("post_id", id))
+		logger.Error("Failed to fetch post", zap.Error(err), zap.Int64("post_id", id))
 		return nil, err
 	}
 
@@ -174,11 +448,12 @@ func (r *StorageDB) GetPost(ctx context.Context, id int64) (*model.Post, error)
 }
 
 func (r *StorageDB) GetCommentsForPost(ctx context.Context, postID int64, offset int64, limit int64) ([]*model.Comment, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
 	query := `SELECT comment_id, author_id, post_id, parent_id, content, created_at
 			  FROM comments WHERE post_id = $1 ORDER BY created_at ASC OFFSET $2 LIMIT $3`
 	rows, err := r.db.Query(ctx, query, postID, offset, limit)
 	if err != nil {
-		r.log.Error("Failed to fetch comments for post", zap.Error(err), zap.Int64("post_id", postID))
+		logger.Error("Failed to fetch comments for post", zap.Error(err), zap.Int64("post_id", postID))
 		return nil, err
 	}
 	defer rows.Close()
@@ -188,21 +463,22 @@ func (r *StorageDB) GetCommentsForPost(ctx context.Context, postID int64, offset
 		comment := &model.Comment{}
 		err := rows.Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
 		if err != nil {
-			r.log.Error("Failed to scan comment", zap.Error(err), zap.Int64("post_id", postID))
+			logger.Error("Failed to scan comment", zap.Error(err), zap.Int64("post_id", postID))
 			return nil, err
 		}
 		comments = append(comments, comment)
 	}
 
 	if err = rows.Err(); err != nil {
-		r.log.Error("Failed to fetch comments for post", zap.Error(err), zap.Int64("post_id", postID))
+		logger.Error("Failed to fetch comments for post", zap.Error(err), zap.Int64("post_id", postID))
 		return nil, err
 	}
-	r.log.Info("Comments fetched successfully", zap.Int("count", len(comments)), zap.Int64("post_id", postID))
+	logger.Debug("Comments fetched successfully", zap.Int("count", len(comments)), zap.Int64("post_id", postID))
 	return comments, nil
 }
 
 func (r *StorageDB) GetRepliesByParentID(ctx context.Context, parentID int64, offset, limit int64) ([]*model.Comment, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
 	query := `SELECT comment_id, author_id, post_id, parent_id, content, created_at
 			  FROM comments WHERE parent_id = $1 
 			  ORDER BY created_at ASC 
@@ -210,7 +486,7 @@ func (r *StorageDB) GetRepliesByParentID(ctx context.Context, parentID int64, of
 
 	rows, err := r.db.Query(ctx, query, parentID, offset, limit)
 	if err != nil {
-		r.log.Error("Failed to fetch replies", zap.Error(err), zap.Int64("parent_id", parentID))
+		logger.Error("Failed to fetch replies", zap.Error(err), zap.Int64("parent_id", parentID))
 		return nil, err
 	}
 	defer rows.Close()
@@ -220,18 +496,18 @@ func (r *StorageDB) GetRepliesByParentID(ctx context.Context, parentID int64, of
 		comment := &model.Comment{}
 		err := rows.Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
 		if err != nil {
-			r.log.Error("Failed to scan reply", zap.Error(err), zap.Int64("parent_id", parentID))
+			logger.Error("Failed to scan reply", zap.Error(err), zap.Int64("parent_id", parentID))
 			return nil, err
 		}
 		replies = append(replies, comment)
 	}
 
 	if err = rows.Err(); err != nil {
-		r.log.Error("Failed to fetch replies", zap.Error(err), zap.Int64("parent_id", parentID))
+		logger.Error("Failed to fetch replies", zap.Error(err), zap.Int64("parent_id", parentID))
 		return nil, err
 	}
 
-	r.log.Info("Replies fetched successfully",
+	logger.Debug("Replies fetched successfully",
 		zap.Int("count", len(replies)),
 		zap.Int64("parent_id", parentID),
 		zap.Int64("offset", offset),
@@ -240,7 +516,56 @@ func (r *StorageDB) GetRepliesByParentID(ctx context.Context, parentID int64, of
 	return replies, nil
 }
 
+func (r *StorageDB) GetCommentsAfter(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
+	query := `SELECT comment_id, author_id, post_id, parent_id, content, created_at
+			  FROM comments WHERE post_id = $1 AND comment_id > $2 ORDER BY comment_id ASC LIMIT $3`
+	rows, err := r.db.Query(ctx, query, postID, afterID, limit)
+	if err != nil {
+		logger.Error("Failed to fetch comments after ID", zap.Error(err), zap.Int64("post_id", postID), zap.Int64("after_id", afterID))
+		return nil, err
+	}
+	comments, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*model.Comment, error) {
+		comment := &model.Comment{}
+		err := row.Scan(&comment.ID, &comment.AuthorID, &comment.PostID, &comment.ParentID, &comment.Content, &comment.CreatedAt)
+		return comment, err
+	})
+	if err != nil {
+		logger.Error("Failed to scan comments after ID", zap.Error(err), zap.Int64("post_id", postID), zap.Int64("after_id", afterID))
+		return nil, err
+	}
+	logger.Debug("Comments after ID fetched", zap.Int("count", len(comments)), zap.Int64("post_id", postID), zap.Int64("after_id", afterID))
+	return comments, nil
+}
+
+// commentPath returns the IDs of a comment and its ancestors, or none if the
+// comment doesn't exist. q is the pool or a transaction.
+func commentPath(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}, commentID int64) ([]int64, error) {
+	query := `
+		WITH RECURSIVE comment_tree AS (
+			SELECT comment_id, parent_id, 0 AS depth
+			FROM comments WHERE comment_id = $1
+
+			UNION ALL
+
+			SELECT c.comment_id, c.parent_id, ct.depth + 1
+			FROM comments c
+			JOIN comment_tree ct ON c.comment_id = ct.parent_id
+		)
+		SELECT comment_id FROM comment_tree ORDER BY depth;
+	`
+
+	rows, err := q.Query(ctx, query, commentID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
 func (r *StorageDB) GetCommentDepth(ctx context.Context, commentID int64) (int, error) {
+	logger := log.FromContext(ctx).Named(log.PackageStorage)
 	query := `
 		WITH RECURSIVE comment_tree AS (
 			SELECT comment_id, parent_id, 0 AS depth
@@ -258,15 +583,88 @@ func (r *StorageDB) GetCommentDepth(ctx context.Context, commentID int64) (int,
 	var depth int
 	err := r.db.QueryRow(ctx, query, commentID).Scan(&depth)
 	if err != nil {
-		r.log.Error("Failed to calculate comment depth",
+		logger.Error("Failed to calculate comment depth",
 			zap.Error(err),
 			zap.Int64("comment_id", commentID))
 		return 0, err
 	}
 
-	r.log.Info("Comment depth calculated",
+	logger.Debug("Comment depth calculated",
 		zap.Int64("comment_id", commentID),
 		zap.Int("depth", depth))
 
 	return depth, nil
 }
+
+// Pool returns the connection pool, e.g. for exporting its statistics.
+func (r *StorageDB) Pool() *pgxpool.Pool {
+	return r.db
+}
+
+// Close closes the connection pool.
+func (r *StorageDB) Close() {
+	r.db.Close()
+}
+
+func (r *StorageDB) Ping(ctx context.Context) error {
+	return r.db.Ping(ctx)
+}
+
+// claimIdempotencyKey reserves key for the current transaction. A concurrent
+// request with the same key blocks on the insert until this transaction
+// commits or rolls back. An expired key that was not purged yet is claimed
+// again. If the key was already used within the window, the ID of the
+// resource it created is returned with claimed == false, or
+// ErrIdempotencyKeyReused if it was used for a different request.
+func (r *StorageDB) claimIdempotencyKey(ctx context.Context, tx pgx.Tx, scope, key, requestHash string) (int64, bool, error) {
+	now := time.Now()
+	tag, err := tx.Exec(ctx, `INSERT INTO idempotency_keys (scope, key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, created_at = EXCLUDED.created_at, resource_id = NULL
+		WHERE idempotency_keys.created_at < $5`, scope, key, requestHash, now, r.expiredBefore(now))
+	if err != nil {
+		return 0, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return 0, true, nil
+	}
+
+	var resourceID int64
+	var storedHash string
+	err = tx.QueryRow(ctx, `SELECT resource_id, request_hash FROM idempotency_keys WHERE scope = $1 AND key = $2`, scope, key).
+		Scan(&resourceID, &storedHash)
+	if err != nil {
+		return 0, false, err
+	}
+	if storedHash != requestHash {
+		return 0, false, errs.ErrIdempotencyKeyReused
+	}
+	return resourceID, false, nil
+}
+
+// expiredBefore returns the creation time before which keys have expired;
+// without a TTL none do.
+func (r *StorageDB) expiredBefore(now time.Time) time.Time {
+	if r.idempotencyTTL <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-r.idempotencyTTL)
+}
+
+// PurgeIdempotencyKeys relies on the index on created_at.
+func (r *StorageDB) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	if r.idempotencyTTL <= 0 {
+		return 0, nil
+	}
+	tag, err := r.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, r.expiredBefore(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *StorageDB) completeIdempotencyKey(ctx context.Context, tx pgx.Tx, scope, key string, resourceID int64) error {
+	_, err := tx.Exec(ctx, `UPDATE idempotency_keys SET resource_id = $1 WHERE scope = $2 AND key = $3`, resourceID, scope, key)
+	return err
+}
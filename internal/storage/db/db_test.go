@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testStorage returns a storage on the database at TEST_DATABASE_URL, with
+// the schema migrated and all tables emptied. The test is skipped when the
+// variable is not set.
+func testStorage(t *testing.T) *StorageDB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	require.NoError(t, prepareSchema(ctx, pool, true, zap.NewNop()))
+	_, err = pool.Exec(ctx, `TRUNCATE posts, comments, idempotency_keys, outbox RESTART IDENTITY CASCADE`)
+	require.NoError(t, err)
+	return NewStorageDB(pool, time.Hour)
+}
+
+func TestGetCommentsAfter_PagesInIDOrder(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	other, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+
+	var ids []int64
+	for i := range 5 {
+		c, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "c"})
+		require.NoError(t, err)
+		ids = append(ids, c.ID)
+		if i == 2 {
+			_, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: other.ID, Content: "c"})
+			require.NoError(t, err)
+		}
+	}
+
+	page, err := s.GetCommentsAfter(ctx, post.ID, ids[1], 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, []int64{ids[2], ids[3]}, []int64{page[0].ID, page[1].ID})
+	assert.Equal(t, post.ID, page[0].PostID)
+
+	page, err = s.GetCommentsAfter(ctx, post.ID, ids[3], 10)
+	require.NoError(t, err)
+	require.Len(t, page, 1, "comments on other posts are skipped")
+	assert.Equal(t, ids[4], page[0].ID)
+
+	page, err = s.GetCommentsAfter(ctx, post.ID, ids[4], 10)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/jackc/pgx/v5"
+)
+
+var _ storage.Outbox = (*StorageDB)(nil)
+
+// outboxLock is the advisory lock held by the instance processing the
+// outbox, so events are delivered in order by one relay at a time.
+const outboxLock int64 = 0x6f7574626f78
+
+type outboxPayload struct {
+	Post      *model.Post    `json:"post,omitempty"`
+	Comment   *model.Comment `json:"comment,omitempty"`
+	Ancestors []int64        `json:"ancestors,omitempty"`
+}
+
+// addEvent records event in tx. Once tx is committed, eventsStored has to be
+// called to wake the relay.
+func (r *StorageDB) addEvent(ctx context.Context, tx pgx.Tx, event *storage.OutboxEvent) error {
+	payload, err := json.Marshal(outboxPayload{Post: event.Post, Comment: event.Comment, Ancestors: event.Ancestors})
+	if err != nil {
+		return err
+	}
+	return tx.QueryRow(ctx, `INSERT INTO outbox (kind, post_id, payload, created_at)
+		VALUES ($1, $2, $3, $4) RETURNING event_id`, event.Kind, event.PostID, payload, event.CreatedAt).Scan(&event.ID)
+}
+
+func (r *StorageDB) eventsStored() {
+	select {
+	case r.outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// ProcessOutbox keeps its transaction open while deliver runs, so events are
+// delivered again if the process dies before they are marked. Events are
+// picked by delivered_at rather than after the last delivered ID: a
+// transaction that committed late can have stored an event below it.
+func (r *StorageDB) ProcessOutbox(ctx context.Context, limit int, deliver func(ctx context.Context, events []*storage.OutboxEvent) (int, error)) (int, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err = tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, outboxLock).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to lock outbox: %w", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	rows, err := tx.Query(ctx, `SELECT event_id, kind, post_id, payload, created_at, attempts
+		FROM outbox WHERE delivered_at IS NULL AND parked_at IS NULL ORDER BY event_id LIMIT $1`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*storage.OutboxEvent, error) {
+		event := &storage.OutboxEvent{}
+		var payload []byte
+		if err := row.Scan(&event.ID, &event.Kind, &event.PostID, &payload, &event.CreatedAt, &event.Attempts); err != nil {
+			return nil, err
+		}
+		var p outboxPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, fmt.Errorf("event %d: %w", event.ID, err)
+		}
+		event.Post, event.Comment, event.Ancestors = p.Post, p.Comment, p.Ancestors
+		return event, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	if len(events) == 0 {
+		return 0, tx.Commit(ctx)
+	}
+
+	n, deliverErr := deliver(ctx, events)
+	n = min(max(n, 0), len(events))
+	now := time.Now()
+	if n > 0 {
+		ids := make([]int64, n)
+		for i, event := range events[:n] {
+			ids[i] = event.ID
+		}
+		if _, err = tx.Exec(ctx, `UPDATE outbox SET delivered_at = $1 WHERE event_id = ANY($2)`, now, ids); err != nil {
+			return 0, fmt.Errorf("failed to mark events delivered: %w", err)
+		}
+	}
+	if deliverErr != nil && n < len(events) {
+		var parkedAt *time.Time
+		if errors.Is(deliverErr, errs.ErrEventParked) {
+			parkedAt = &now
+		}
+		_, err = tx.Exec(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = $1, parked_at = $2 WHERE event_id = $3`,
+			deliverErr.Error(), parkedAt, events[n].ID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to record delivery error: %w", err)
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *StorageDB) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM outbox WHERE delivered_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *StorageDB) OutboxReady() <-chan struct{} {
+	return r.outboxReady
+}
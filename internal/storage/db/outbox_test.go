@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collect returns a deliver function that keeps the events it gets and
+// reports the first n of them delivered, with err.
+func collect(got *[]*storage.OutboxEvent, n int, err error) func(context.Context, []*storage.OutboxEvent) (int, error) {
+	return func(ctx context.Context, events []*storage.OutboxEvent) (int, error) {
+		*got = events
+		return min(n, len(events)), err
+	}
+}
+
+func ids(events []*storage.OutboxEvent) []int64 {
+	out := make([]int64, len(events))
+	for i, event := range events {
+		out[i] = event.ID
+	}
+	return out
+}
+
+func TestProcessOutbox_DeliversInOrderAndRecordsFailures(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	comment, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "first"})
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, ParentID: &comment.ID, Content: "reply"})
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "last"})
+	require.NoError(t, err)
+
+	var events []*storage.OutboxEvent
+	n, err := s.ProcessOutbox(ctx, 10, collect(&events, 1, errors.New("sink unavailable")))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []int64{1, 2, 3, 4}, ids(events))
+	assert.Equal(t, storage.EventPostCreated, events[0].Kind)
+	assert.Equal(t, "t", events[0].Post.Title)
+	assert.Equal(t, storage.EventCommentAdded, events[2].Kind)
+	assert.Equal(t, "reply", events[2].Comment.Content)
+	assert.Equal(t, []int64{comment.ID}, events[2].Ancestors)
+
+	var attempts int
+	var lastError string
+	require.NoError(t, s.db.QueryRow(ctx, `SELECT attempts, last_error FROM outbox WHERE event_id = 2`).Scan(&attempts, &lastError))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, "sink unavailable", lastError)
+
+	n, err = s.ProcessOutbox(ctx, 10, collect(&events, 1, fmt.Errorf("%w: sink unavailable", errs.ErrEventParked)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []int64{2, 3, 4}, ids(events), "the failed event is retried first")
+	assert.Equal(t, 1, events[0].Attempts)
+
+	n, err = s.ProcessOutbox(ctx, 10, collect(&events, 10, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []int64{4}, ids(events), "the parked event is skipped")
+
+	events = nil
+	n, err = s.ProcessOutbox(ctx, 10, collect(&events, 10, nil))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Nil(t, events, "deliver is not called without events")
+}
+
+func TestProcessOutbox_OneCallerAtATime(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	_, err := s.CreatePost(ctx, &model.NewPost{AuthorID: uuid.New(), Title: "t", Content: "c"})
+	require.NoError(t, err)
+
+	var nested int
+	_, err = s.ProcessOutbox(ctx, 10, func(ctx context.Context, events []*storage.OutboxEvent) (int, error) {
+		// Another connection of the pool stands for another instance.
+		var err error
+		nested, err = s.ProcessOutbox(ctx, 10, func(context.Context, []*storage.OutboxEvent) (int, error) {
+			t.Error("events were passed to a second caller")
+			return 0, nil
+		})
+		require.NoError(t, err)
+		return len(events), nil
+	})
+	require.NoError(t, err)
+	assert.Zero(t, nested)
+}
+
+func TestPruneOutbox_KeepsPendingAndParkedEvents(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	for range 4 {
+		_, err := s.CreatePost(ctx, &model.NewPost{AuthorID: uuid.New(), Title: "t", Content: "c"})
+		require.NoError(t, err)
+	}
+	var events []*storage.OutboxEvent
+	// Events 1 and 2 are delivered, 3 is parked and 4 stays pending.
+	_, err := s.ProcessOutbox(ctx, 3, collect(&events, 2, fmt.Errorf("%w: sink unavailable", errs.ErrEventParked)))
+	require.NoError(t, err)
+
+	pruned, err := s.PruneOutbox(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, pruned, "recent events are kept")
+
+	pruned, err = s.PruneOutbox(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pruned)
+
+	rows, err := s.db.Query(ctx, `SELECT event_id FROM outbox ORDER BY event_id`)
+	require.NoError(t, err)
+	var left []int64
+	for rows.Next() {
+		var id int64
+		require.NoError(t, rows.Scan(&id))
+		left = append(left, id)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []int64{3, 4}, left)
+}
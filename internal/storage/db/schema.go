@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// prepareSchema applies pending migrations when autoMigrate is set and
+// refuses to continue against a schema older than the binary expects.
+func prepareSchema(ctx context.Context, pool *pgxpool.Pool, autoMigrate bool, logger *zap.Logger) error {
+	migrator, err := migrations.NewMigrator(pool)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	if autoMigrate {
+		results, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to apply migrations: %w", err)
+		}
+		for _, result := range results {
+			logger.Info("Migration applied", zap.String("migration", result.Source.Path), zap.Duration("duration", result.Duration))
+		}
+	}
+	if err := migrator.Check(ctx); err != nil {
+		return fmt.Errorf("%w; run `migrate up` or set DB_AUTO_MIGRATE=true", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+)
+
+var _ storage.Admin = (*StorageMemory)(nil)
+
+func (s *StorageMemory) Dump(ctx context.Context) (*storage.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := &storage.Snapshot{
+		Posts:    make([]*model.Post, 0, len(s.posts)),
+		Comments: make([]*model.Comment, 0, len(s.commentMap)),
+	}
+	for _, post := range s.posts {
+		p := *post
+		snapshot.Posts = append(snapshot.Posts, &p)
+	}
+	for _, comment := range s.commentMap {
+		c := *comment
+		snapshot.Comments = append(snapshot.Comments, &c)
+	}
+	sort.Slice(snapshot.Posts, func(i, j int) bool { return snapshot.Posts[i].ID < snapshot.Posts[j].ID })
+	sort.Slice(snapshot.Comments, func(i, j int) bool { return snapshot.Comments[i].ID < snapshot.Comments[j].ID })
+	return snapshot, nil
+}
+
+func (s *StorageMemory) Restore(ctx context.Context, snapshot *storage.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	posts := map[int64]*model.Post{}
+	for _, post := range snapshot.Posts {
+		if _, exists := s.posts[post.ID]; exists || posts[post.ID] != nil {
+			return fmt.Errorf("post %d already exists", post.ID)
+		}
+		p := *post
+		p.Comments = nil
+		posts[p.ID] = &p
+	}
+	comments := make([]*model.Comment, 0, len(snapshot.Comments))
+	commentIDs := map[int64]bool{}
+	for _, comment := range snapshot.Comments {
+		if _, exists := s.commentMap[comment.ID]; exists || commentIDs[comment.ID] {
+			return fmt.Errorf("comment %d already exists", comment.ID)
+		}
+		c := *comment
+		c.Replies = nil
+		comments = append(comments, &c)
+		commentIDs[c.ID] = true
+	}
+	for _, comment := range comments {
+		if _, exists := s.posts[comment.PostID]; !exists && posts[comment.PostID] == nil {
+			return fmt.Errorf("comment %d: %w", comment.ID, errs.ErrPostNotFound)
+		}
+		if comment.ParentID != nil {
+			if _, exists := s.commentMap[*comment.ParentID]; !exists && !commentIDs[*comment.ParentID] {
+				return fmt.Errorf("comment %d: %w", comment.ID, errs.ErrParentCommentNotFound)
+			}
+		}
+	}
+
+	for id, post := range posts {
+		s.posts[id] = post
+	}
+	// Comments of a post are kept in ID order, and restored ones can have
+	// lower IDs than those already stored.
+	restored := map[int64]bool{}
+	for _, comment := range comments {
+		s.comments[comment.PostID] = append(s.comments[comment.PostID], comment)
+		s.commentMap[comment.ID] = comment
+		restored[comment.PostID] = true
+	}
+	for postID := range restored {
+		postComments := s.comments[postID]
+		sort.Slice(postComments, func(i, j int) bool { return postComments[i].ID < postComments[j].ID })
+	}
+	s.recount()
+	return nil
+}
+
+func (s *StorageMemory) PurgePost(ctx context.Context, postID int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.posts[postID]; !exists {
+		return 0, errs.ErrPostNotFound
+	}
+	removed := map[int64]bool{}
+	for _, comment := range s.comments[postID] {
+		removed[comment.ID] = true
+		delete(s.commentMap, comment.ID)
+	}
+	delete(s.comments, postID)
+	delete(s.posts, postID)
+
+	for key, record := range s.idempotency {
+		switch {
+		case strings.HasPrefix(key, idempotency.ScopePost+":") && record.resourceID == postID,
+			strings.HasPrefix(key, idempotency.ScopeComment+":") && removed[record.resourceID]:
+			delete(s.idempotency, key)
+		}
+	}
+	return int64(len(removed)), nil
+}
+
+func (s *StorageMemory) Recount(ctx context.Context) (storage.Counts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recount()
+	return storage.Counts{Posts: int64(len(s.posts)), Comments: int64(len(s.commentMap))}, nil
+}
+
+// recount moves the ID counters past the highest stored ID. Counters never
+// go back, so IDs of purged rows are not handed out again.
+func (s *StorageMemory) recount() {
+	for id := range s.posts {
+		if id >= s.postCounter {
+			s.postCounter = id + 1
+		}
+	}
+	for id := range s.commentMap {
+		if id >= s.commentCounter {
+			s.commentCounter = id + 1
+		}
+	}
+}
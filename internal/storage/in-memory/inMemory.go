@@ -2,31 +2,78 @@ package inmemory
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
 	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
 )
 
+type idempotencyRecord struct {
+	resourceID  int64
+	requestHash string
+	createdAt   time.Time
+}
+
 type StorageMemory struct {
 	posts          map[int64]*model.Post
 	comments       map[int64][]*model.Comment
 	commentMap     map[int64]*model.Comment
+	idempotency    map[string]idempotencyRecord
+	idempotencyTTL time.Duration
 	postCounter    int64
 	commentCounter int64
+	// outbox holds the events in ID order; the first outboxNext of them
+	// are delivered. outboxMu is held while they are being delivered.
+	outbox        []*outboxRecord
+	outboxNext    int
+	outboxCounter int64
+	outboxReady   chan struct{}
+	outboxMu      sync.Mutex
+	mu            sync.RWMutex
 }
 
-func NewStorageMemory() *StorageMemory {
+func init() {
+	storage.Register(storage.StorageTypeMemory, func(ctx context.Context, cfg *config.Config) (storage.Storage, error) {
+		return NewStorageMemory(cfg.Idempotency.TTL), nil
+	})
+}
+
+func NewStorageMemory(idempotencyTTL time.Duration) *StorageMemory {
 	return &StorageMemory{
 		posts:          make(map[int64]*model.Post),
 		comments:       make(map[int64][]*model.Comment),
 		commentMap:     make(map[int64]*model.Comment),
+		idempotency:    make(map[string]idempotencyRecord),
+		idempotencyTTL: idempotencyTTL,
 		postCounter:    0,
 		commentCounter: 0,
+		outboxReady:    make(chan struct{}, 1),
 	}
 }
 
 func (s *StorageMemory) CreatePost(ctx context.Context, newPost *model.NewPost) (*model.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scope := idempotency.Scope(idempotency.ScopePost, newPost.AuthorID)
+	requestHash := idempotency.Fingerprint(newPost.Title, newPost.Content, newPost.CommentsAllowed)
+	if newPost.IdempotencyKey != nil {
+		postID, ok, err := s.lookupIdempotencyKey(scope, *newPost.IdempotencyKey, requestHash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			idempotency.MarkReplayed(ctx)
+			return s.posts[postID], nil
+		}
+	}
+
 	post := &model.Post{
 		ID:              s.postCounter,
 		AuthorID:        newPost.AuthorID,
@@ -37,10 +84,31 @@ func (s *StorageMemory) CreatePost(ctx context.Context, newPost *model.NewPost)
 	}
 	s.posts[post.ID] = post
 	s.postCounter++
+
+	if newPost.IdempotencyKey != nil {
+		s.storeIdempotencyKey(scope, *newPost.IdempotencyKey, requestHash, post.ID)
+	}
+	s.addEvent(storage.PostEvent(storage.EventPostCreated, post))
 	return post, nil
 }
 
 func (s *StorageMemory) CreateComment(ctx context.Context, newComment *model.NewComment) (*model.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scope := idempotency.Scope(idempotency.ScopeComment, newComment.AuthorID)
+	requestHash := idempotency.Fingerprint(newComment.PostID, newComment.ParentID, newComment.Content)
+	if newComment.IdempotencyKey != nil {
+		commentID, ok, err := s.lookupIdempotencyKey(scope, *newComment.IdempotencyKey, requestHash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			idempotency.MarkReplayed(ctx)
+			return s.commentMap[commentID], nil
+		}
+	}
+
 	post, exists := s.posts[newComment.PostID]
 	if !exists {
 		return nil, errs.ErrPostNotFound
@@ -68,19 +136,108 @@ func (s *StorageMemory) CreateComment(ctx context.Context, newComment *model.New
 	s.commentMap[comment.ID] = comment
 	s.commentCounter++
 
+	if newComment.IdempotencyKey != nil {
+		s.storeIdempotencyKey(scope, *newComment.IdempotencyKey, requestHash, comment.ID)
+	}
+	var ancestors []int64
+	if comment.ParentID != nil {
+		ancestors = s.commentPath(*comment.ParentID)
+	}
+	s.addEvent(storage.CommentEvent(storage.EventCommentAdded, comment, ancestors...))
 	return comment, nil
 }
 
 func (s *StorageMemory) AllowComments(ctx context.Context, authorID string, postID int64, allowed bool) (*model.Post, error) {
-	post, exists := s.posts[postID]
-	if !exists || post.AuthorID.String() != authorID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.posts[postID]
+	if !exists || stored.AuthorID.String() != authorID {
 		return nil, errs.ErrPostNotFound
 	}
+	post := *stored
 	post.CommentsAllowed = allowed
-	return post, nil
+	s.posts[postID] = &post
+	s.addEvent(storage.PostEvent(storage.EventCommentsToggled, &post))
+	return &post, nil
+}
+
+func (s *StorageMemory) UpdatePost(ctx context.Context, authorID string, postID int64, title, content *string) (*model.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.posts[postID]
+	if !exists || stored.AuthorID.String() != authorID {
+		return nil, errs.ErrPostNotFound
+	}
+	// Readers may still hold the stored post, so a changed copy replaces
+	// it.
+	post := *stored
+	if title != nil {
+		post.Title = *title
+	}
+	if content != nil {
+		post.Content = *content
+	}
+	s.posts[postID] = &post
+	s.addEvent(storage.PostEvent(storage.EventPostUpdated, &post))
+	return &post, nil
+}
+
+func (s *StorageMemory) EditComment(ctx context.Context, authorID string, commentID int64, content string) (*model.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.commentMap[commentID]
+	if !exists || stored.AuthorID.String() != authorID {
+		return nil, errs.ErrCommentNotFound
+	}
+	// As in UpdatePost, the stored comment is replaced by a changed copy.
+	comment := *stored
+	comment.Content = content
+	comments := s.comments[comment.PostID]
+	i := sort.Search(len(comments), func(i int) bool { return comments[i].ID >= commentID })
+	comments[i] = &comment
+	s.commentMap[commentID] = &comment
+	s.addEvent(storage.CommentEvent(storage.EventCommentEdited, &comment))
+	return &comment, nil
+}
+
+func (s *StorageMemory) DeleteComment(ctx context.Context, authorID string, commentID int64) (*model.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, exists := s.commentMap[commentID]
+	if !exists || comment.AuthorID.String() != authorID {
+		return nil, errs.ErrCommentNotFound
+	}
+
+	// Comments of a post are in ID order, so replies come after their parent.
+	removed := map[int64]bool{commentID: true}
+	kept := s.comments[comment.PostID][:0:0]
+	for _, c := range s.comments[comment.PostID] {
+		if removed[c.ID] || (c.ParentID != nil && removed[*c.ParentID]) {
+			removed[c.ID] = true
+			delete(s.commentMap, c.ID)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	s.comments[comment.PostID] = kept
+
+	for key, record := range s.idempotency {
+		if strings.HasPrefix(key, idempotency.ScopeComment+":") && removed[record.resourceID] {
+			delete(s.idempotency, key)
+		}
+	}
+	s.addEvent(storage.CommentEvent(storage.EventCommentDeleted, comment))
+	return comment, nil
 }
 
 func (s *StorageMemory) GetPosts(ctx context.Context) ([]*model.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	posts := make([]*model.Post, 0, len(s.posts))
 	for _, post := range s.posts {
 		posts = append(posts, post)
@@ -89,6 +246,9 @@ func (s *StorageMemory) GetPosts(ctx context.Context) ([]*model.Post, error) {
 }
 
 func (s *StorageMemory) GetPost(ctx context.Context, id int64) (*model.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if post, exists := s.posts[id]; exists {
 		return post, nil
 	}
@@ -96,6 +256,9 @@ func (s *StorageMemory) GetPost(ctx context.Context, id int64) (*model.Post, err
 }
 
 func (s *StorageMemory) GetCommentsForPost(ctx context.Context, postID int64, offset int64, limit int64) ([]*model.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	comments, exists := s.comments[postID]
 	if !exists {
 		return nil, nil
@@ -110,10 +273,13 @@ func (s *StorageMemory) GetCommentsForPost(ctx context.Context, postID int64, of
 		end = int64(len(comments))
 	}
 
-	return comments[offset:end], nil
+	return append([]*model.Comment(nil), comments[offset:end]...), nil
 }
 
 func (s *StorageMemory) GetRepliesByParentID(ctx context.Context, parentID int64, offset, limit int64) ([]*model.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	var replies []*model.Comment
 	for _, comments := range s.comments {
 		for _, comment := range comments {
@@ -135,7 +301,35 @@ func (s *StorageMemory) GetRepliesByParentID(ctx context.Context, parentID int64
 	return replies[offset:end], nil
 }
 
+// GetCommentsAfter relies on comments of a post being stored in ID order.
+func (s *StorageMemory) GetCommentsAfter(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comments := s.comments[postID]
+	start := sort.Search(len(comments), func(i int) bool { return comments[i].ID > afterID })
+	end := min(start+int(limit), len(comments))
+	return append([]*model.Comment(nil), comments[start:end]...), nil
+}
+
+// commentPath returns the IDs of an existing comment and its ancestors.
+func (s *StorageMemory) commentPath(commentID int64) []int64 {
+	comment := s.commentMap[commentID]
+	path := []int64{comment.ID}
+	for comment.ParentID != nil {
+		var exists bool
+		if comment, exists = s.commentMap[*comment.ParentID]; !exists {
+			break
+		}
+		path = append(path, comment.ID)
+	}
+	return path
+}
+
 func (s *StorageMemory) GetCommentDepth(ctx context.Context, commentID int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	depth := 0
 	currentID := commentID
 
@@ -150,3 +344,46 @@ func (s *StorageMemory) GetCommentDepth(ctx context.Context, commentID int64) (i
 
 	return depth, nil
 }
+
+func (s *StorageMemory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// lookupIdempotencyKey returns the resource created with key, or
+// ErrIdempotencyKeyReused if key was sent with a different request.
+func (s *StorageMemory) lookupIdempotencyKey(scope, key, requestHash string) (int64, bool, error) {
+	record, ok := s.idempotency[scope+"/"+key]
+	if !ok {
+		return 0, false, nil
+	}
+	if s.expired(record) {
+		delete(s.idempotency, scope+"/"+key)
+		return 0, false, nil
+	}
+	if record.requestHash != requestHash {
+		return 0, false, errs.ErrIdempotencyKeyReused
+	}
+	return record.resourceID, true, nil
+}
+
+func (s *StorageMemory) storeIdempotencyKey(scope, key, requestHash string, resourceID int64) {
+	s.idempotency[scope+"/"+key] = idempotencyRecord{resourceID: resourceID, requestHash: requestHash, createdAt: time.Now()}
+}
+
+func (s *StorageMemory) expired(record idempotencyRecord) bool {
+	return s.idempotencyTTL > 0 && time.Since(record.createdAt) > s.idempotencyTTL
+}
+
+func (s *StorageMemory) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, record := range s.idempotency {
+		if s.expired(record) {
+			delete(s.idempotency, key)
+			n++
+		}
+	}
+	return n, nil
+}
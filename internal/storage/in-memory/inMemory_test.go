@@ -0,0 +1,435 @@
+package inmemory_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/idempotency"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	inmemory "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func commentIDs(comments []*model.Comment) []int64 {
+	ids := make([]int64, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+	return ids
+}
+
+func TestCreateComment_IdempotencyKeyReplay(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	post, err := s.CreatePost(context.Background(), &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+
+	input := &model.NewComment{AuthorID: author, PostID: post.ID, Content: "hello", IdempotencyKey: ptr("retry-1")}
+
+	first, err := s.CreateComment(idempotency.Track(context.Background()), input)
+	require.NoError(t, err)
+
+	ctx := idempotency.Track(context.Background())
+	second, err := s.CreateComment(ctx, input)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.ID, second.ID)
+	assert.True(t, idempotency.Replayed(ctx))
+	comments, err := s.GetCommentsForPost(context.Background(), post.ID, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, comments, 1)
+}
+
+func TestCreatePost_ConcurrentDuplicatesInsertOnce(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	input := &model.NewPost{AuthorID: uuid.New(), Title: "t", Content: "c", IdempotencyKey: ptr("retry-1")}
+
+	var wg sync.WaitGroup
+	ids := make([]int64, 20)
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			post, err := s.CreatePost(context.Background(), input)
+			assert.NoError(t, err)
+			ids[i] = post.ID
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+	posts, err := s.GetPosts(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+}
+
+func TestCreatePost_KeysAreScopedPerAuthor(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+
+	first, err := s.CreatePost(context.Background(), &model.NewPost{AuthorID: uuid.New(), Title: "a", IdempotencyKey: ptr("same")})
+	require.NoError(t, err)
+	second, err := s.CreatePost(context.Background(), &model.NewPost{AuthorID: uuid.New(), Title: "b", IdempotencyKey: ptr("same")})
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first.ID, second.ID)
+}
+
+func TestDumpRestore_RoundTripKeepsIDs(t *testing.T) {
+	ctx := context.Background()
+	src := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	post, err := src.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	parent, err := src.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "first"})
+	require.NoError(t, err)
+	_, err = src.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, ParentID: &parent.ID, Content: "reply"})
+	require.NoError(t, err)
+	_, err = src.AllowComments(ctx, author.String(), post.ID, false)
+	require.NoError(t, err)
+
+	snapshot, err := src.Dump(ctx)
+	require.NoError(t, err)
+	dst := inmemory.NewStorageMemory(time.Hour)
+	require.NoError(t, dst.Restore(ctx, snapshot))
+
+	restored, err := dst.Dump(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, restored)
+
+	next, err := dst.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, post.ID+1, next.ID)
+
+	assert.ErrorContains(t, dst.Restore(ctx, snapshot), "already exists")
+}
+
+func TestRestore_KeepsCommentsInIDOrder(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	require.NoError(t, s.Restore(ctx, &storage.Snapshot{
+		Posts:    []*model.Post{{ID: 1, AuthorID: author}},
+		Comments: []*model.Comment{{ID: 5, PostID: 1, AuthorID: author}},
+	}))
+	require.NoError(t, s.Restore(ctx, &storage.Snapshot{
+		Comments: []*model.Comment{{ID: 3, PostID: 1, AuthorID: author}, {ID: 7, PostID: 1, AuthorID: author}},
+	}))
+
+	after, err := s.GetCommentsAfter(ctx, 1, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 5, 7}, commentIDs(after))
+	after, err = s.GetCommentsAfter(ctx, 1, 4, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5, 7}, commentIDs(after))
+}
+
+func TestRestore_RejectsMissingParent(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	snapshot := &storage.Snapshot{
+		Posts:    []*model.Post{{ID: 1, AuthorID: uuid.New()}},
+		Comments: []*model.Comment{{ID: 1, PostID: 1, ParentID: ptr(int64(7))}},
+	}
+
+	assert.ErrorIs(t, s.Restore(context.Background(), snapshot), errs.ErrParentCommentNotFound)
+	posts, err := s.GetPosts(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+}
+
+func TestPurgePost_RemovesCommentsAndKeys(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	input := &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true, IdempotencyKey: ptr("k")}
+	post, err := s.CreatePost(ctx, input)
+	require.NoError(t, err)
+	for range 3 {
+		_, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "x"})
+		require.NoError(t, err)
+	}
+
+	removed, err := s.PurgePost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), removed)
+	_, err = s.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, errs.ErrPostNotFound)
+
+	// The key no longer replays the purged post.
+	again, err := s.CreatePost(ctx, input)
+	require.NoError(t, err)
+	assert.NotEqual(t, post.ID, again.ID)
+
+	_, err = s.PurgePost(ctx, post.ID)
+	assert.ErrorIs(t, err, errs.ErrPostNotFound)
+
+	counts, err := s.Recount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counts{Posts: 1, Comments: 0}, counts)
+}
+
+func TestGetCommentsAfter_PagesInIDOrder(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	ctx := context.Background()
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	other, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+
+	var ids []int64
+	for i := range 5 {
+		c, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "c"})
+		require.NoError(t, err)
+		ids = append(ids, c.ID)
+		if i == 2 {
+			_, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: other.ID, Content: "c"})
+			require.NoError(t, err)
+		}
+	}
+
+	page, err := s.GetCommentsAfter(ctx, post.ID, ids[1], 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, []int64{ids[2], ids[3]}, []int64{page[0].ID, page[1].ID})
+
+	page, err = s.GetCommentsAfter(ctx, post.ID, ids[4], 10)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+func TestDeleteComment_RemovesRepliesAndKeys(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	ctx := context.Background()
+	author, other := uuid.New(), uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	root, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "root"})
+	require.NoError(t, err)
+	reply, err := s.CreateComment(ctx, &model.NewComment{AuthorID: other, PostID: post.ID, ParentID: &root.ID, Content: "reply", IdempotencyKey: ptr("k")})
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: other, PostID: post.ID, ParentID: &reply.ID, Content: "nested"})
+	require.NoError(t, err)
+	kept, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "kept"})
+	require.NoError(t, err)
+
+	_, err = s.DeleteComment(ctx, other.String(), root.ID)
+	assert.ErrorIs(t, err, errs.ErrCommentNotFound, "only the author may delete a comment")
+
+	deleted, err := s.DeleteComment(ctx, author.String(), root.ID)
+	require.NoError(t, err)
+	assert.Equal(t, root.ID, deleted.ID)
+
+	comments, err := s.GetCommentsForPost(ctx, post.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.Equal(t, kept.ID, comments[0].ID)
+
+	// The key of the deleted reply creates a new comment instead of
+	// replaying a removed one.
+	again, err := s.CreateComment(ctx, &model.NewComment{AuthorID: other, PostID: post.ID, Content: "reply", IdempotencyKey: ptr("k")})
+	require.NoError(t, err)
+	assert.NotEqual(t, reply.ID, again.ID)
+}
+
+func TestEditCommentAndUpdatePost_CheckAuthor(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	ctx := context.Background()
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	comment, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "c"})
+	require.NoError(t, err)
+
+	_, err = s.EditComment(ctx, uuid.NewString(), comment.ID, "x")
+	assert.ErrorIs(t, err, errs.ErrCommentNotFound)
+	edited, err := s.EditComment(ctx, author.String(), comment.ID, "edited")
+	require.NoError(t, err)
+	assert.Equal(t, "edited", edited.Content)
+
+	_, err = s.UpdatePost(ctx, uuid.NewString(), post.ID, ptr("x"), nil)
+	assert.ErrorIs(t, err, errs.ErrPostNotFound)
+	updated, err := s.UpdatePost(ctx, author.String(), post.ID, nil, ptr("new content"))
+	require.NoError(t, err)
+	assert.Equal(t, "t", updated.Title)
+	assert.Equal(t, "new content", updated.Content)
+}
+
+func TestAllowComments_LeavesReadPostUnchanged(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	ctx := context.Background()
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+
+	read, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	toggled, err := s.AllowComments(ctx, author.String(), post.ID, false)
+	require.NoError(t, err)
+	assert.False(t, toggled.CommentsAllowed)
+	assert.True(t, read.CommentsAllowed, "a post read before the toggle is not changed")
+
+	read, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.False(t, read.CommentsAllowed)
+}
+
+func TestEditCommentAndUpdatePost_LeaveReadValuesUnchanged(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	ctx := context.Background()
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "first"})
+	require.NoError(t, err)
+	comment, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "c"})
+	require.NoError(t, err)
+
+	read, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	comments, err := s.GetCommentsForPost(ctx, post.ID, 0, 10)
+	require.NoError(t, err)
+
+	_, err = s.UpdatePost(ctx, author.String(), post.ID, ptr("new title"), nil)
+	require.NoError(t, err)
+	_, err = s.EditComment(ctx, author.String(), comment.ID, "edited")
+	require.NoError(t, err)
+	assert.Equal(t, "t", read.Title, "a post read before the update is not changed")
+	assert.Equal(t, "c", comments[1].Content, "a comment read before the edit is not changed")
+
+	read, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "new title", read.Title)
+	comments, err = s.GetCommentsForPost(ctx, post.ID, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", comments[1].Content)
+	after, err := s.GetCommentsAfter(ctx, post.ID, comment.ID-1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", after[0].Content)
+}
+
+func TestCreateComment_RecordsAncestorsOfReply(t *testing.T) {
+	s := inmemory.NewStorageMemory(time.Hour)
+	ctx := context.Background()
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+
+	var parent *int64
+	var ids []int64
+	for range 3 {
+		c, err := s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, ParentID: parent, Content: "c"})
+		require.NoError(t, err)
+		ids = append(ids, c.ID)
+		parent = &c.ID
+	}
+
+	events := pending(t, s)
+	require.Len(t, events, 4)
+	assert.Empty(t, events[1].Ancestors)
+	assert.Equal(t, []int64{ids[1], ids[0]}, events[3].Ancestors, "nearest ancestor first")
+}
+
+// pending returns the undelivered events of s without delivering them.
+func pending(t *testing.T, s *inmemory.StorageMemory) []*storage.OutboxEvent {
+	var events []*storage.OutboxEvent
+	_, err := s.ProcessOutbox(context.Background(), 100, func(ctx context.Context, batch []*storage.OutboxEvent) (int, error) {
+		events = batch
+		return 0, nil
+	})
+	require.NoError(t, err)
+	return events
+}
+
+func TestOutbox_RecordsEachChangeOnce(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	input := &model.NewPost{AuthorID: uuid.New(), Title: "t", Content: "c", IdempotencyKey: ptr("retry-1")}
+	post, err := s.CreatePost(ctx, input)
+	require.NoError(t, err)
+	_, err = s.CreatePost(ctx, input)
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: uuid.New(), PostID: post.ID, Content: "c"})
+	require.ErrorIs(t, err, errs.ErrCommentsNotAllowed)
+	_, err = s.AllowComments(ctx, input.AuthorID.String(), post.ID, true)
+	require.NoError(t, err)
+
+	events := pending(t, s)
+	require.Len(t, events, 2, "a replay and a failed write record nothing")
+	assert.Equal(t, storage.EventPostCreated, events[0].Kind)
+	assert.False(t, events[0].Post.CommentsAllowed, "events keep the state they recorded")
+	assert.Equal(t, storage.EventCommentsToggled, events[1].Kind)
+	select {
+	case <-s.OutboxReady():
+	default:
+		t.Fatal("stored events were not reported")
+	}
+}
+
+func TestOutbox_ProcessMarksDeliveredAndCountsFailures(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	for range 3 {
+		_, err := s.CreatePost(ctx, &model.NewPost{AuthorID: uuid.New(), Title: "t", Content: "c"})
+		require.NoError(t, err)
+	}
+
+	n, err := s.ProcessOutbox(ctx, 10, func(ctx context.Context, events []*storage.OutboxEvent) (int, error) {
+		return 1, assert.AnError
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	events := pending(t, s)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(1), events[0].ID)
+	assert.Equal(t, 1, events[0].Attempts)
+
+	pruned, err := s.PruneOutbox(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned, "only delivered events are pruned")
+	assert.Len(t, pending(t, s), 2)
+}
+
+func TestCreateComment_KeyReusedForDifferentRequest(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(time.Hour)
+	author := uuid.New()
+	post, err := s.CreatePost(ctx, &model.NewPost{AuthorID: author, Title: "t", Content: "c", CommentsAllowed: true})
+	require.NoError(t, err)
+
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "hello", IdempotencyKey: ptr("retry-1")})
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, &model.NewComment{AuthorID: author, PostID: post.ID, Content: "bye", IdempotencyKey: ptr("retry-1")})
+	assert.ErrorIs(t, err, errs.ErrIdempotencyKeyReused)
+
+	comments, err := s.GetCommentsForPost(ctx, post.ID, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, comments, 1)
+}
+
+func TestPurgeIdempotencyKeys_RemovesExpiredKeysOfAllAuthors(t *testing.T) {
+	ctx := context.Background()
+	s := inmemory.NewStorageMemory(20 * time.Millisecond)
+	for range 2 {
+		_, err := s.CreatePost(ctx, &model.NewPost{AuthorID: uuid.New(), Title: "t", IdempotencyKey: ptr("k")})
+		require.NoError(t, err)
+	}
+	n, err := s.PurgeIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	time.Sleep(30 * time.Millisecond)
+	n, err = s.PurgeIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
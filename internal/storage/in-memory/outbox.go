@@ -0,0 +1,97 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/utils/errs"
+)
+
+var _ storage.Outbox = (*StorageMemory)(nil)
+
+type outboxRecord struct {
+	event       *storage.OutboxEvent
+	deliveredAt time.Time
+	// parkedAt is set on an event whose delivery was given up, and lastError
+	// keeps why it failed.
+	parkedAt  time.Time
+	lastError string
+}
+
+// addEvent records event in the outbox. s.mu must be held for writing, so
+// the event is stored together with its change.
+func (s *StorageMemory) addEvent(event *storage.OutboxEvent) {
+	event.ID = s.outboxCounter
+	s.outboxCounter++
+	s.outbox = append(s.outbox, &outboxRecord{event: event})
+	select {
+	case s.outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// ProcessOutbox delivers events strictly in order, so the delivered and
+// parked ones are always the first outboxNext of the outbox.
+func (s *StorageMemory) ProcessOutbox(ctx context.Context, limit int, deliver func(ctx context.Context, events []*storage.OutboxEvent) (int, error)) (int, error) {
+	if !s.outboxMu.TryLock() {
+		return 0, nil
+	}
+	defer s.outboxMu.Unlock()
+
+	s.mu.RLock()
+	pending := s.outbox[s.outboxNext:min(s.outboxNext+limit, len(s.outbox))]
+	events := make([]*storage.OutboxEvent, len(pending))
+	for i, record := range pending {
+		event := *record.event
+		events[i] = &event
+	}
+	s.mu.RUnlock()
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	n, deliverErr := deliver(ctx, events)
+	n = min(max(n, 0), len(events))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, record := range s.outbox[s.outboxNext : s.outboxNext+n] {
+		record.deliveredAt = now
+	}
+	s.outboxNext += n
+	if deliverErr != nil && n < len(events) {
+		record := s.outbox[s.outboxNext]
+		record.event.Attempts++
+		record.lastError = deliverErr.Error()
+		if errors.Is(deliverErr, errs.ErrEventParked) {
+			record.parkedAt = now
+			s.outboxNext++
+		}
+	}
+	return n, nil
+}
+
+func (s *StorageMemory) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	kept := make([]*outboxRecord, 0, len(s.outbox))
+	for i, record := range s.outbox {
+		if i < s.outboxNext && record.parkedAt.IsZero() && record.deliveredAt.Before(before) {
+			n++
+			continue
+		}
+		kept = append(kept, record)
+	}
+	s.outbox = kept
+	s.outboxNext -= n
+	return int64(n), nil
+}
+
+func (s *StorageMemory) OutboxReady() <-chan struct{} {
+	return s.outboxReady
+}
@@ -2,23 +2,36 @@ package storage
 
 import (
 	"context"
-	"os"
 
 	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
-	dbs "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/db"
-	inmemory "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Storage interface {
 	CreatePost(ctx context.Context, newPost *model.NewPost) (*model.Post, error)
 	CreateComment(ctx context.Context, newComment *model.NewComment) (*model.Comment, error)
 	AllowComments(ctx context.Context, authorID string, postID int64, allowed bool) (*model.Post, error)
+	// UpdatePost changes the title and content of a post, keeping those
+	// passed as nil.
+	UpdatePost(ctx context.Context, authorID string, postID int64, title, content *string) (*model.Post, error)
+	EditComment(ctx context.Context, authorID string, commentID int64, content string) (*model.Comment, error)
+	// DeleteComment removes a comment with all its replies and returns it.
+	DeleteComment(ctx context.Context, authorID string, commentID int64) (*model.Comment, error)
 	GetPosts(ctx context.Context) ([]*model.Post, error)
 	GetPost(ctx context.Context, id int64) (*model.Post, error)
 	GetCommentsForPost(ctx context.Context, postID int64, offset int64, limit int64) ([]*model.Comment, error)
 	GetCommentDepth(ctx context.Context, commentID int64) (int, error)
 	GetRepliesByParentID(ctx context.Context, parentID int64, offset, limit int64) ([]*model.Comment, error)
+	// GetCommentsAfter returns up to limit comments on postID with IDs above
+	// afterID, in ID order.
+	GetCommentsAfter(ctx context.Context, postID, afterID, limit int64) ([]*model.Comment, error)
+	Ping(ctx context.Context) error
+}
+
+// IdempotencyKeys is implemented by backends that store idempotency keys.
+type IdempotencyKeys interface {
+	// PurgeIdempotencyKeys removes the expired keys of all authors and
+	// returns how many were removed.
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
 }
 
 type StorageType string
@@ -27,22 +40,3 @@ const (
 	StorageTypeDB     StorageType = "db"
 	StorageTypeMemory StorageType = "memory"
 )
-
-func NewStorage(ctx context.Context, db *pgxpool.Pool) Storage {
-	storageType := StorageType(os.Getenv("STORAGE_TYPE"))
-
-	switch storageType {
-	case StorageTypeMemory:
-		return inmemory.NewStorageMemory()
-	case StorageTypeDB:
-		if db == nil {
-			panic("database connection is required for database storage")
-		}
-		return dbs.NewStorageDB(db)
-	default:
-		if db != nil {
-			return dbs.NewStorageDB(db)
-		}
-		return inmemory.NewStorageMemory()
-	}
-}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/graph/model"
+)
+
+// EventKind tells what change an outbox event records.
+type EventKind string
+
+const (
+	EventPostCreated     EventKind = "post_created"
+	EventPostUpdated     EventKind = "post_updated"
+	EventCommentsToggled EventKind = "comments_toggled"
+	EventCommentAdded    EventKind = "comment_added"
+	EventCommentEdited   EventKind = "comment_edited"
+	EventCommentDeleted  EventKind = "comment_deleted"
+)
+
+// OutboxEvent is a domain event, stored in the same transaction as the
+// change it records. Post is set for post events and Comment for comment
+// events; Ancestors of an added reply are the IDs of its parent and the
+// parent's ancestors, nearest first.
+type OutboxEvent struct {
+	ID        int64          `json:"id"`
+	Kind      EventKind      `json:"kind"`
+	PostID    int64          `json:"postID"`
+	Post      *model.Post    `json:"post,omitempty"`
+	Comment   *model.Comment `json:"comment,omitempty"`
+	Ancestors []int64        `json:"ancestors,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	// Attempts counts the failed deliveries of the event.
+	Attempts int `json:"attempts"`
+}
+
+// Outbox is implemented by backends that record domain events with the
+// writes of Storage, for a relay to deliver them at least once.
+type Outbox interface {
+	// ProcessOutbox passes up to limit undelivered events, oldest first, to
+	// deliver and marks the first n of them delivered, where n is what
+	// deliver returns. If deliver also returns an error, it is recorded on
+	// event n, whose delivery failed. If the error wraps errs.ErrEventParked,
+	// event n is parked as well: it is kept with its error but never passed
+	// to deliver again, so the events after it can be delivered. Only one
+	// caller at a time, on any instance, gets events; the others get none.
+	ProcessOutbox(ctx context.Context, limit int, deliver func(ctx context.Context, events []*OutboxEvent) (int, error)) (int, error)
+	// PruneOutbox removes the events delivered before the given time and
+	// returns how many were removed. Parked events are kept.
+	PruneOutbox(ctx context.Context, before time.Time) (int64, error)
+	// OutboxReady receives a value after this process stores new events.
+	OutboxReady() <-chan struct{}
+}
+
+func PostEvent(kind EventKind, post *model.Post) *OutboxEvent {
+	p := *post
+	return &OutboxEvent{Kind: kind, PostID: post.ID, Post: &p, CreatedAt: time.Now()}
+}
+
+// CommentEvent records a change to comment. For an added reply, ancestors
+// must hold the path from its parent to the top-level comment.
+func CommentEvent(kind EventKind, comment *model.Comment, ancestors ...int64) *OutboxEvent {
+	c := *comment
+	return &OutboxEvent{Kind: kind, PostID: comment.PostID, Comment: &c, Ancestors: ancestors, CreatedAt: time.Now()}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+)
+
+// Factory opens a storage backend, creating only the dependencies that
+// backend needs. Backends that hold resources also implement Close().
+type Factory func(ctx context.Context, cfg *config.Config) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[StorageType]Factory{}
+)
+
+// Register makes a backend available to Open. It is called from the init
+// function of the backend's package and panics on duplicate names.
+func Register(storageType StorageType, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := factories[storageType]; dup {
+		panic("storage: Register called twice for " + string(storageType))
+	}
+	factories[storageType] = factory
+}
+
+// Registered returns the names of all registered backends.
+func Registered() []StorageType {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]StorageType, 0, len(factories))
+	for storageType := range factories {
+		types = append(types, storageType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Open creates the backend registered under storageType.
+func Open(ctx context.Context, storageType StorageType, cfg *config.Config) (Storage, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[storageType]
+	factoriesMu.RUnlock()
+	if !ok {
+		names := make([]string, 0)
+		for _, registered := range Registered() {
+			names = append(names, string(registered))
+		}
+		return nil, fmt.Errorf("unknown storage type %q, registered: %s", storageType, strings.Join(names, ", "))
+	}
+
+	s, err := factory(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", storageType, err)
+	}
+	return s, nil
+}
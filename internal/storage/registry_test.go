@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/config"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/mocks"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/storage"
+	inmemory "github.com/iamstep4ik/TestTaskOzonBank/internal/storage/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestOpen_RegisteredBackend(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	fake := mocks.NewMockStorage(ctrl)
+	var gotCfg *config.Config
+	storage.Register("fake", func(ctx context.Context, cfg *config.Config) (storage.Storage, error) {
+		gotCfg = cfg
+		return fake, nil
+	})
+
+	cfg := &config.Config{}
+	st, err := storage.Open(context.Background(), "fake", cfg)
+	require.NoError(t, err)
+	assert.Same(t, fake, st)
+	assert.Same(t, cfg, gotCfg)
+	assert.Panics(t, func() {
+		storage.Register("fake", func(context.Context, *config.Config) (storage.Storage, error) { return nil, nil })
+	})
+}
+
+func TestOpen_MemoryNeedsNoDatabase(t *testing.T) {
+	st, err := storage.Open(context.Background(), storage.StorageTypeMemory, &config.Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &inmemory.StorageMemory{}, st)
+	assert.NoError(t, st.Ping(context.Background()))
+}
+
+func TestOpen_FailsFast(t *testing.T) {
+	_, err := storage.Open(context.Background(), "cassandra", &config.Config{})
+	assert.ErrorContains(t, err, `unknown storage type "cassandra"`)
+	assert.ErrorContains(t, err, "memory")
+
+	storage.Register("broken", func(context.Context, *config.Config) (storage.Storage, error) {
+		return nil, errors.New("no route to host")
+	})
+	_, err = storage.Open(context.Background(), "broken", &config.Config{})
+	assert.ErrorContains(t, err, "failed to open broken storage: no route to host")
+}
@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Middleware continues the trace started by the caller, if the request
+// carries W3C traceparent/tracestate headers.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// FromInitPayload continues a trace passed in the websocket connection_init
+// payload, for clients that can't set headers on the upgrade request.
+func FromInitPayload(ctx context.Context, payload transport.InitPayload) context.Context {
+	carrier := propagation.MapCarrier{}
+	for _, key := range otel.GetTextMapPropagator().Fields() {
+		if value := payload.GetString(key); value != "" {
+			carrier[key] = value
+		}
+	}
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
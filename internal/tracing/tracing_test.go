@@ -0,0 +1,56 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/iamstep4ik/TestTaskOzonBank/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+)
+
+func initPropagators(t *testing.T) {
+	shutdown, err := tracing.Initialize(context.Background(), tracing.Config{Exporter: tracing.ExporterNone})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+}
+
+func TestMiddlewareExtractsTraceparent(t *testing.T) {
+	initPropagators(t)
+
+	var got trace.SpanContext
+	handler := tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.Header.Set("traceparent", traceparent)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, got.IsRemote())
+	assert.Equal(t, traceID, got.TraceID().String())
+}
+
+func TestFromInitPayload(t *testing.T) {
+	initPropagators(t)
+
+	ctx := tracing.FromInitPayload(context.Background(), transport.InitPayload{"traceparent": traceparent})
+	assert.Equal(t, traceID, trace.SpanContextFromContext(ctx).TraceID().String())
+
+	ctx = tracing.FromInitPayload(context.Background(), transport.InitPayload{})
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
+
+func TestInitializeUnknownExporter(t *testing.T) {
+	_, err := tracing.Initialize(context.Background(), tracing.Config{Exporter: "jaeger"})
+	assert.Error(t, err)
+}
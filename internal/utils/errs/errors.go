@@ -4,6 +4,7 @@ import "errors"
 
 var (
 	ErrCommentContent         = errors.New("comment content must be between 1 and 2000 characters")
+	ErrPostContent            = errors.New("post title and content must not be empty")
 	ErrCommentNotFound        = errors.New("comment not found")
 	ErrPostNotFound           = errors.New("post not found")
 	ErrInternalServerError    = errors.New("internal server error")
@@ -11,4 +12,8 @@ var (
 	ErrIncorrectCommentLength = errors.New("incorrect comment lenth")
 	ErrCommentsNotAllowed     = errors.New("comments not allowed")
 	ErrParentCommentNotFound  = errors.New("parent comment not found")
+	ErrInvalidIdempotencyKey  = errors.New("idempotency key must be between 1 and 128 characters")
+	ErrIdempotencyKeyReused   = errors.New("idempotency key was already used for a different request")
+	ErrUnauthorized           = errors.New("missing or invalid access token")
+	ErrEventParked            = errors.New("event parked")
 )
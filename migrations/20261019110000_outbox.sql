@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS outbox (
+    event_id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    post_id BIGINT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    parked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_outbox_pending ON outbox(event_id) WHERE delivered_at IS NULL AND parked_at IS NULL;
+CREATE INDEX idx_outbox_delivered_at ON outbox(delivered_at) WHERE delivered_at IS NOT NULL;
+CREATE INDEX idx_outbox_parked_at ON outbox(parked_at) WHERE parked_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_outbox_parked_at;
+DROP INDEX IF EXISTS idx_outbox_delivered_at;
+DROP INDEX IF EXISTS idx_outbox_pending;
+
+DROP TABLE IF EXISTS outbox;